@@ -3,51 +3,136 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/url"
 	"os"
 	"os/signal"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+	kclock "k8s.io/utils/clock"
 	_ "modernc.org/sqlite"
 
 	"reminders-demo/pkg/reminders"
 )
 
 func main() {
+	// Load the options from the config file, env vars, and flags
+	opts, err := LoadOptions(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	} else if err != nil {
+		fatal("Failed to load options", err)
+	}
+
+	// Create the logger, which is also used as default logger
+	logger := opts.newLogger(os.Stderr)
+	slog.SetDefault(logger)
+
 	// Connect to the database
 	db, err := connectDB("data.db")
 	if err != nil {
-		log.Fatal(err)
+		fatal("Failed to connect to the database", err)
 	}
 	defer db.Close()
 
-	// Ensure that the table exists
-	err = ensureTable(db)
+	// Register the metrics, which are exposed by the server
+	metrics, err := reminders.NewMetrics(prometheus.DefaultRegisterer)
 	if err != nil {
-		log.Fatal(err)
+		fatal("Failed to register metrics", err)
+	}
+
+	// Create the table or upgrade it to the current schema
+	store := reminders.NewSQLiteStore(db, metrics, logger)
+	err = store.Migrate(context.Background())
+	if err != nil {
+		fatal("Failed to migrate database", err)
+	}
+
+	// Create the tracer provider, if spans are exported
+	tp, err := opts.newTracerProvider(os.Stdout)
+	if err != nil {
+		fatal("Failed to create tracer provider", err)
+	}
+	var tracerProvider trace.TracerProvider
+	if tp != nil {
+		tracerProvider = tp
+		logger.Info("Exporting spans", slog.String("exporter", opts.TraceExporter))
 	}
 
 	// Create the reminders object
-	reminders := NewReminders(db)
+	rm := NewReminders(store, kclock.RealClock{}, opts, metrics, tracerProvider, logger)
+	logger.Info("Instance started", slog.String("instance", rm.ownerID))
+	if len(opts.ActorTypes) > 0 {
+		rm.SetHostedActors(actorTypeFilters(opts.ActorTypes))
+		logger.Info("Hosted actor types", slog.Any("actorTypes", opts.ActorTypes))
+	}
 
-	// Poll for reminders
-	go reminders.PollReminders(context.Background())
+	// Watch for reminders that are due soon
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		err := rm.WatchReminders(ctx)
+		if err != nil {
+			fatal("Failed to watch reminders", err)
+		}
+	}()
 
 	// Start a server to get user input
-	go reminders.startServer()
+	go rm.startServer()
 
 	// Sleep until context is canceled
-	sigCh := make(chan os.Signal)
+	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, os.Interrupt, os.Kill)
 	<-sigCh
-	log.Println("Shutting down")
+	logger.Info("Shutting down")
+
+	// Stop watching, then release the leases on the reminders that are still in the queue
+	cancel()
+	closeCtx, closeCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer closeCancel()
+	err = rm.Close(closeCtx)
+	if err != nil {
+		logger.Error("Error while shutting down", slog.Any("error", err))
+	}
+
+	// Flush the spans that haven't been exported yet
+	if tp != nil {
+		err = tp.Shutdown(closeCtx)
+		if err != nil {
+			logger.Error("Error shutting down tracer provider", slog.Any("error", err))
+		}
+	}
+}
+
+// Returns the filters that select all reminders for the given actor types.
+func actorTypeFilters(actorTypes []string) []reminders.ActorFilter {
+	res := make([]reminders.ActorFilter, len(actorTypes))
+	for i, t := range actorTypes {
+		res[i] = reminders.ActorFilter{ActorType: t}
+	}
+	return res
 }
 
-// Invoked when a reminder is executed.
-func executeReminder(r *reminders.Reminder) {
-	log.Printf("Executed reminder %s - scheduled for %s", r.Key(), r.ExecutionTime.Local().Format(time.RFC822))
+// Invoked when a reminder is executed, if no app address is configured.
+func executeReminder(ctx context.Context, r *reminders.Reminder) error {
+	// If the processor is stopping, do not execute the reminder, so its lease is released and another instance executes it
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+	slog.Info("Executed reminder", slog.Any("reminder", r), slog.String("data", string(r.Data)))
+	return nil
+}
+
+// Logs the error with the default logger and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, slog.Any("error", err))
+	os.Exit(1)
 }
 
 func connectDB(file string) (*sql.DB, error) {
@@ -67,21 +152,3 @@ func getConnectionString(file string) string {
 
 	return "file:" + file + "?" + qs.Encode()
 }
-
-func ensureTable(db *sql.DB) error {
-	_, err := db.ExecContext(context.TODO(),
-		`CREATE TABLE IF NOT EXISTS reminders (
-			target TEXT NOT NULL PRIMARY KEY,
-			execution_time INTEGER NOT NULL,
-			period INTEGER,
-			ttl INTEGER,
-			data BLOB,
-			lease_time INTEGER NOT NULL
-		);
-
-		CREATE INDEX IF NOT EXISTS execution_time_idx ON reminders (execution_time ASC);
-		CREATE INDEX IF NOT EXISTS lease_time_idx ON reminders (lease_time ASC);
-		`,
-	)
-	return err
-}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"gopkg.in/yaml.v3"
+
+	"reminders-demo/pkg/reminders"
+)
+
+// Options contains the tuning knobs for Reminders.
+type Options struct {
+	// How often to poll for new rows
+	// When a batch is full, the next poll happens right away
+	PollInterval time.Duration `yaml:"pollInterval"`
+	// When no reminder is due, the interval between polls is doubled every time, up to this value
+	MaxPollInterval time.Duration `yaml:"maxPollInterval"`
+	// When fetching reminders, only look for those scheduled to be executed within this time interval
+	FetchAhead time.Duration `yaml:"fetchAhead"`
+	// Lease duration
+	// While a reminder is being executed, its lease is renewed every third of this interval
+	LeaseDuration time.Duration `yaml:"leaseDuration"`
+	// Maximum number of reminders fetched in batch in each iteration
+	BatchSize int `yaml:"batchSize"`
+	// Maximum number of reminders in the in-memory queue; no more reminders are fetched while the queue is full
+	// 0 means no limit
+	MaxQueued int `yaml:"maxQueued"`
+	// Maximum number of reminders executed concurrently; 0 means no limit
+	MaxConcurrent int `yaml:"maxConcurrent"`
+	// Maximum number of reminders for the same actor type executed concurrently; 0 means no limit
+	MaxConcurrentPerActorType int `yaml:"maxConcurrentPerActorType"`
+	// Maximum number of due reminders waiting for a worker when the concurrency limits are reached
+	MaxPending int `yaml:"maxPending"`
+	// What to do with due reminders when the concurrency limits are reached and the pending buffer is full:
+	// "wait" keeps them in the queue until there's room, and "release" releases their lease so other instances can execute them
+	// With "wait", reminders whose actor type is at its limit are released too, so they don't hold back the reminders of other actor types
+	SaturationPolicy string `yaml:"saturationPolicy"`
+	// Maximum size of the data of a reminder, in bytes; 0 means no limit
+	MaxDataSize int `yaml:"maxDataSize"`
+	// If true, reminders that are added and scheduled within FetchAhead are leased and enqueued by this instance right away
+	// This reduces latency, but it can lead to a less uniform distribution of reminders across instances
+	LocalEnqueue bool `yaml:"localEnqueue"`
+	// Actor types hosted by this instance; only reminders for these actor types are acquired
+	// If empty, reminders for all actor types are acquired
+	ActorTypes []string `yaml:"actorTypes"`
+	// Minimum level of log messages: "debug", "info", "warn", or "error"
+	LogLevel string `yaml:"logLevel"`
+	// Format of log messages: "text" or "json"
+	LogFormat string `yaml:"logFormat"`
+	// Exporter for the spans about reminders: "none" discards them, and "stdout" writes them to stdout as JSON
+	TraceExporter string `yaml:"traceExporter"`
+	// Base URL of the actor app that reminders are delivered to, such as "http://127.0.0.1:3000"
+	// If empty, reminders are only logged
+	AppAddress string `yaml:"appAddress"`
+	// Timeout for delivering a reminder to the app; deliveries that time out are treated as failures
+	AppTimeout time.Duration `yaml:"appTimeout"`
+	// Delay before retrying a reminder that failed to execute for the first time
+	RetryInitialInterval time.Duration `yaml:"retryInitialInterval"`
+	// Maximum delay between retries
+	RetryMaxInterval time.Duration `yaml:"retryMaxInterval"`
+	// Factor the delay between retries is multiplied by after each failed attempt
+	RetryMultiplier float64 `yaml:"retryMultiplier"`
+	// Randomization factor for the delay between retries, between 0 and 1
+	RetryJitter float64 `yaml:"retryJitter"`
+	// Maximum number of attempts to execute each iteration of a reminder; 0 means no limit
+	RetryMaxAttempts int `yaml:"retryMaxAttempts"`
+}
+
+// DefaultOptions returns the default options.
+func DefaultOptions() Options {
+	return Options{
+		PollInterval:     2500 * time.Millisecond,
+		MaxPollInterval:  5 * time.Second,
+		FetchAhead:       5 * time.Second,
+		LeaseDuration:    30 * time.Second,
+		BatchSize:        2,
+		MaxQueued:        1000,
+		MaxConcurrent:    100,
+		MaxPending:       100,
+		SaturationPolicy: "wait",
+		MaxDataSize:      64 << 10,
+		LocalEnqueue:     true,
+		LogLevel:         "info",
+		LogFormat:        "text",
+		TraceExporter:    "none",
+		AppTimeout:       10 * time.Second,
+
+		RetryInitialInterval: time.Second,
+		RetryMaxInterval:     time.Minute,
+		RetryMultiplier:      2,
+		RetryJitter:          0.2,
+		RetryMaxAttempts:     5,
+	}
+}
+
+// Minimum ratio between LeaseDuration and FetchAhead
+// Reminders can wait in the queue for up to FetchAhead before they're executed, and the lease must not expire in the meanwhile
+const minLeaseFetchAheadRatio = 3
+
+// Validate returns an error if the options are not valid.
+func (o Options) Validate() error {
+	if o.PollInterval <= 0 {
+		return errors.New("pollInterval must be greater than zero")
+	}
+	if o.MaxPollInterval < o.PollInterval {
+		return fmt.Errorf("maxPollInterval (%v) must not be smaller than pollInterval (%v)", o.MaxPollInterval, o.PollInterval)
+	}
+	if o.FetchAhead < o.MaxPollInterval {
+		return fmt.Errorf("fetchAhead (%v) must not be smaller than maxPollInterval (%v), or reminders may be executed late", o.FetchAhead, o.MaxPollInterval)
+	}
+	if o.FetchAhead < o.PollInterval {
+		return fmt.Errorf("fetchAhead (%v) must not be smaller than pollInterval (%v), or reminders may be executed late", o.FetchAhead, o.PollInterval)
+	}
+	if o.LeaseDuration < minLeaseFetchAheadRatio*o.FetchAhead {
+		return fmt.Errorf("leaseDuration (%v) must be at least %d times fetchAhead (%v), or leases may expire before reminders are executed", o.LeaseDuration, minLeaseFetchAheadRatio, o.FetchAhead)
+	}
+	if o.BatchSize <= 0 {
+		return errors.New("batchSize must be greater than zero")
+	}
+	if o.MaxQueued < 0 {
+		return errors.New("maxQueued must not be negative")
+	}
+	if o.MaxConcurrent < 0 {
+		return errors.New("maxConcurrent must not be negative")
+	}
+	if o.MaxConcurrentPerActorType < 0 {
+		return errors.New("maxConcurrentPerActorType must not be negative")
+	}
+	if o.MaxPending < 0 {
+		return errors.New("maxPending must not be negative")
+	}
+	if o.SaturationPolicy != "wait" && o.SaturationPolicy != "release" {
+		return fmt.Errorf("saturationPolicy '%s' is not valid: must be 'wait' or 'release'", o.SaturationPolicy)
+	}
+	if o.MaxDataSize < 0 {
+		return errors.New("maxDataSize must not be negative")
+	}
+	_, err := o.logLevel()
+	if err != nil {
+		return err
+	}
+	if o.LogFormat != "text" && o.LogFormat != "json" {
+		return fmt.Errorf("logFormat '%s' is not valid: must be 'text' or 'json'", o.LogFormat)
+	}
+	if o.TraceExporter != "none" && o.TraceExporter != "stdout" {
+		return fmt.Errorf("traceExporter '%s' is not valid: must be 'none' or 'stdout'", o.TraceExporter)
+	}
+	if o.AppAddress != "" {
+		u, err := url.Parse(o.AppAddress)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("appAddress '%s' is not valid: must be a http or https URL", o.AppAddress)
+		}
+	}
+	if o.AppTimeout <= 0 {
+		return errors.New("appTimeout must be greater than zero")
+	}
+	if o.RetryInitialInterval <= 0 {
+		return errors.New("retryInitialInterval must be greater than zero")
+	}
+	if o.RetryMaxInterval < o.RetryInitialInterval {
+		return fmt.Errorf("retryMaxInterval (%v) must not be smaller than retryInitialInterval (%v)", o.RetryMaxInterval, o.RetryInitialInterval)
+	}
+	if o.RetryMultiplier < 1 {
+		return errors.New("retryMultiplier must not be smaller than 1")
+	}
+	if o.RetryJitter < 0 || o.RetryJitter > 1 {
+		return errors.New("retryJitter must be between 0 and 1")
+	}
+	if o.RetryMaxAttempts < 0 {
+		return errors.New("retryMaxAttempts must not be negative")
+	}
+	return nil
+}
+
+// Returns the minimum level of log messages.
+func (o Options) logLevel() (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(o.LogLevel))
+	if err != nil {
+		return 0, fmt.Errorf("logLevel '%s' is not valid: must be 'debug', 'info', 'warn', or 'error'", o.LogLevel)
+	}
+	return level, nil
+}
+
+// Returns a logger that writes to w, with the level and format set in the options.
+// The options must have been validated with Options.Validate.
+func (o Options) newLogger(w io.Writer) *slog.Logger {
+	level, _ := o.logLevel()
+	handlerOpts := &slog.HandlerOptions{Level: level}
+	if o.LogFormat == "json" {
+		return slog.New(slog.NewJSONHandler(w, handlerOpts))
+	}
+	return slog.New(slog.NewTextHandler(w, handlerOpts))
+}
+
+// Returns the tracer provider that exports spans with the configured exporter, writing to w for the "stdout" exporter.
+// It returns nil if spans are not exported.
+func (o Options) newTracerProvider(w io.Writer) (*sdktrace.TracerProvider, error) {
+	if o.TraceExporter != "stdout" {
+		return nil, nil
+	}
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(w))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdout exporter: %w", err)
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attribute.String("service.name", reminders.TracerName)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create resource: %w", err)
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	), nil
+}
+
+// Returns the policy for retrying reminders that failed to execute.
+func (o Options) retryPolicy() *reminders.RetryPolicy {
+	return &reminders.RetryPolicy{
+		InitialInterval: o.RetryInitialInterval,
+		MaxInterval:     o.RetryMaxInterval,
+		Multiplier:      o.RetryMultiplier,
+		Jitter:          o.RetryJitter,
+		MaxAttempts:     o.RetryMaxAttempts,
+	}
+}
+
+// Returns the interval at which leases are renewed while reminders are being executed.
+func (o Options) leaseRenewInterval() time.Duration {
+	return o.LeaseDuration / 3
+}
+
+// LoadOptions returns the options, starting from the defaults and applying, in order of increasing precedence:
+// - The optional config file (YAML or JSON), whose path is set with the "-config" flag or the CONFIG_FILE env var
+// - Env vars, named after the flags (e.g. POLL_INTERVAL for "-poll-interval")
+// - Command-line flags
+func LoadOptions(args []string, getenv func(string) string) (Options, error) {
+	opts := DefaultOptions()
+
+	fs := flag.NewFlagSet("reminders-demo", flag.ContinueOnError)
+	configFile := fs.String("config", getenv("CONFIG_FILE"), "Path to a YAML or JSON config file")
+	fs.DurationVar(&opts.PollInterval, "poll-interval", opts.PollInterval, "How often to poll for reminders")
+	fs.DurationVar(&opts.MaxPollInterval, "max-poll-interval", opts.MaxPollInterval, "Maximum interval between polls when no reminder is due")
+	fs.DurationVar(&opts.FetchAhead, "fetch-ahead", opts.FetchAhead, "Fetch reminders scheduled to be executed within this interval")
+	fs.DurationVar(&opts.LeaseDuration, "lease-duration", opts.LeaseDuration, "Duration of leases on reminders")
+	fs.IntVar(&opts.BatchSize, "batch-size", opts.BatchSize, "Maximum number of reminders fetched in each batch")
+	fs.IntVar(&opts.MaxQueued, "max-queued", opts.MaxQueued, "Maximum number of reminders in the in-memory queue (0 for no limit)")
+	fs.IntVar(&opts.MaxConcurrent, "max-concurrent", opts.MaxConcurrent, "Maximum number of reminders executed concurrently (0 for no limit)")
+	fs.IntVar(&opts.MaxConcurrentPerActorType, "max-concurrent-per-actor-type", opts.MaxConcurrentPerActorType, "Maximum number of reminders for the same actor type executed concurrently (0 for no limit)")
+	fs.IntVar(&opts.MaxPending, "max-pending", opts.MaxPending, "Maximum number of due reminders waiting for a worker")
+	fs.StringVar(&opts.SaturationPolicy, "saturation-policy", opts.SaturationPolicy, "What to do with due reminders when no worker is available and the pending buffer is full: wait or release")
+	fs.IntVar(&opts.MaxDataSize, "max-data-size", opts.MaxDataSize, "Maximum size of the data of a reminder, in bytes (0 for no limit)")
+	fs.BoolVar(&opts.LocalEnqueue, "local-enqueue", opts.LocalEnqueue, "Enqueue reminders scheduled within fetch-ahead right away when they're added")
+	fs.Var((*stringSliceValue)(&opts.ActorTypes), "actor-types", "Comma-separated list of actor types hosted by this instance (empty for all)")
+	fs.StringVar(&opts.LogLevel, "log-level", opts.LogLevel, "Minimum level of log messages: debug, info, warn, or error")
+	fs.StringVar(&opts.LogFormat, "log-format", opts.LogFormat, "Format of log messages: text or json")
+	fs.StringVar(&opts.TraceExporter, "trace-exporter", opts.TraceExporter, "Exporter for the spans about reminders: none or stdout")
+	fs.StringVar(&opts.AppAddress, "app-address", opts.AppAddress, "Base URL of the actor app that reminders are delivered to (empty to only log reminders)")
+	fs.DurationVar(&opts.AppTimeout, "app-timeout", opts.AppTimeout, "Timeout for delivering a reminder to the app")
+	fs.DurationVar(&opts.RetryInitialInterval, "retry-initial-interval", opts.RetryInitialInterval, "Delay before retrying a reminder that failed to execute for the first time")
+	fs.DurationVar(&opts.RetryMaxInterval, "retry-max-interval", opts.RetryMaxInterval, "Maximum delay between retries")
+	fs.Float64Var(&opts.RetryMultiplier, "retry-multiplier", opts.RetryMultiplier, "Factor the delay between retries is multiplied by after each failed attempt")
+	fs.Float64Var(&opts.RetryJitter, "retry-jitter", opts.RetryJitter, "Randomization factor for the delay between retries, between 0 and 1")
+	fs.IntVar(&opts.RetryMaxAttempts, "retry-max-attempts", opts.RetryMaxAttempts, "Maximum number of attempts to execute each iteration of a reminder (0 for no limit)")
+	err := fs.Parse(args)
+	if err != nil {
+		return Options{}, err
+	}
+
+	// Flags were parsed into opts, but they need to be applied last
+	setFlags := map[string]string{}
+	fs.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = f.Value.String()
+	})
+	opts = DefaultOptions()
+
+	if *configFile != "" {
+		err = loadOptionsFile(*configFile, &opts)
+		if err != nil {
+			return Options{}, err
+		}
+	}
+
+	var envErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		envName := strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		v := getenv(envName)
+		if v == "" || f.Name == "config" || envErr != nil {
+			return
+		}
+		err := fs.Set(f.Name, v)
+		if err != nil {
+			envErr = fmt.Errorf("invalid value for env var %s: %w", envName, err)
+		}
+	})
+	if envErr != nil {
+		return Options{}, envErr
+	}
+
+	for name, v := range setFlags {
+		// Values were already parsed successfully once
+		_ = fs.Set(name, v)
+	}
+
+	err = opts.Validate()
+	if err != nil {
+		return Options{}, fmt.Errorf("invalid options: %w", err)
+	}
+	return opts, nil
+}
+
+// Loads options from a YAML or JSON file into opts.
+// Fields that are not set in the file are left unchanged.
+func loadOptionsFile(path string, opts *Options) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer f.Close()
+
+	// JSON is a subset of YAML, so the YAML decoder can parse both
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	err = dec.Decode(opts)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file '%s': %w", path, err)
+	}
+	return nil
+}
+
+// stringSliceValue implements flag.Value for a list of comma-separated strings.
+type stringSliceValue []string
+
+func (v *stringSliceValue) String() string {
+	return strings.Join(*v, ",")
+}
+
+func (v *stringSliceValue) Set(val string) error {
+	res := []string{}
+	for _, s := range strings.Split(val, ",") {
+		s = strings.TrimSpace(s)
+		if s != "" {
+			res = append(res, s)
+		}
+	}
+	*v = res
+	return nil
+}
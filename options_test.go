@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadOptions(t *testing.T) {
+	// Returns a getenv function that reads from the map
+	envFn := func(env map[string]string) func(string) string {
+		return func(key string) string {
+			return env[key]
+		}
+	}
+
+	writeFile := func(t *testing.T, name string, content string) string {
+		t.Helper()
+
+		path := filepath.Join(t.TempDir(), name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		return path
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		opts, err := LoadOptions(nil, envFn(nil))
+		require.NoError(t, err)
+		assert.Equal(t, DefaultOptions(), opts)
+	})
+
+	t.Run("env vars and flags", func(t *testing.T) {
+		opts, err := LoadOptions(
+			[]string{"-batch-size", "50", "-local-enqueue=false"},
+			envFn(map[string]string{
+				"BATCH_SIZE":    "10",
+				"POLL_INTERVAL": "1s",
+				"MAX_DATA_SIZE": "0",
+				"ACTOR_TYPES":   "type1, type2,",
+				"LOG_FORMAT":    "json",
+			}),
+		)
+		require.NoError(t, err)
+		assert.Equal(t, 50, opts.BatchSize)
+		assert.Equal(t, time.Second, opts.PollInterval)
+		assert.Equal(t, 0, opts.MaxDataSize)
+		assert.False(t, opts.LocalEnqueue)
+		assert.Equal(t, []string{"type1", "type2"}, opts.ActorTypes)
+		assert.Equal(t, "json", opts.LogFormat)
+		assert.Equal(t, DefaultOptions().FetchAhead, opts.FetchAhead)
+	})
+
+	t.Run("YAML config file", func(t *testing.T) {
+		path := writeFile(t, "config.yaml", "batchSize: 20\nfetchAhead: 10s\nleaseDuration: 1m\n")
+
+		opts, err := LoadOptions(
+			[]string{"-config", path, "-lease-duration", "2m"},
+			envFn(map[string]string{"BATCH_SIZE": "30"}),
+		)
+		require.NoError(t, err)
+		assert.Equal(t, 30, opts.BatchSize)
+		assert.Equal(t, 10*time.Second, opts.FetchAhead)
+		assert.Equal(t, 2*time.Minute, opts.LeaseDuration)
+	})
+
+	t.Run("JSON config file from env var", func(t *testing.T) {
+		path := writeFile(t, "config.json", `{"batchSize": 20, "localEnqueue": false}`)
+
+		opts, err := LoadOptions(nil, envFn(map[string]string{"CONFIG_FILE": path}))
+		require.NoError(t, err)
+		assert.Equal(t, 20, opts.BatchSize)
+		assert.False(t, opts.LocalEnqueue)
+	})
+
+	t.Run("unknown fields in config file", func(t *testing.T) {
+		path := writeFile(t, "config.yaml", "batch: 20\n")
+
+		_, err := LoadOptions([]string{"-config", path}, envFn(nil))
+		require.ErrorContains(t, err, "failed to parse config file")
+	})
+
+	t.Run("invalid env var", func(t *testing.T) {
+		_, err := LoadOptions(nil, envFn(map[string]string{"FETCH_AHEAD": "soon"}))
+		require.ErrorContains(t, err, "FETCH_AHEAD")
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		_, err := LoadOptions([]string{"-fetch-ahead", "20s"}, envFn(nil))
+		require.ErrorContains(t, err, "leaseDuration")
+	})
+}
+
+func TestOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(o *Options)
+		wantErr string
+	}{
+		{name: "defaults are valid", modify: func(o *Options) {}},
+		{name: "poll interval is zero", modify: func(o *Options) { o.PollInterval = 0 }, wantErr: "pollInterval"},
+		{name: "fetch ahead is smaller than poll interval", modify: func(o *Options) { o.FetchAhead = time.Second }, wantErr: "fetchAhead"},
+		{name: "max poll interval is smaller than poll interval", modify: func(o *Options) { o.MaxPollInterval = time.Second }, wantErr: "maxPollInterval"},
+		{name: "max poll interval is larger than fetch ahead", modify: func(o *Options) { o.MaxPollInterval = 10 * time.Second }, wantErr: "fetchAhead"},
+		{name: "max queued is negative", modify: func(o *Options) { o.MaxQueued = -1 }, wantErr: "maxQueued"},
+		{name: "lease duration is too short", modify: func(o *Options) { o.LeaseDuration = 10 * time.Second }, wantErr: "leaseDuration"},
+		{name: "batch size is zero", modify: func(o *Options) { o.BatchSize = 0 }, wantErr: "batchSize"},
+		{name: "max concurrent is negative", modify: func(o *Options) { o.MaxConcurrent = -1 }, wantErr: "maxConcurrent"},
+		{name: "no concurrency limit", modify: func(o *Options) { o.MaxConcurrent = 0 }},
+		{name: "max concurrent per actor type is negative", modify: func(o *Options) { o.MaxConcurrentPerActorType = -1 }, wantErr: "maxConcurrentPerActorType"},
+		{name: "max pending is negative", modify: func(o *Options) { o.MaxPending = -1 }, wantErr: "maxPending"},
+		{name: "release when saturated", modify: func(o *Options) { o.SaturationPolicy = "release" }},
+		{name: "invalid saturation policy", modify: func(o *Options) { o.SaturationPolicy = "drop" }, wantErr: "saturationPolicy"},
+		{name: "max data size is negative", modify: func(o *Options) { o.MaxDataSize = -1 }, wantErr: "maxDataSize"},
+		{name: "no data size limit", modify: func(o *Options) { o.MaxDataSize = 0 }},
+		{name: "debug log level", modify: func(o *Options) { o.LogLevel = "debug" }},
+		{name: "invalid log level", modify: func(o *Options) { o.LogLevel = "verbose" }, wantErr: "logLevel"},
+		{name: "JSON log format", modify: func(o *Options) { o.LogFormat = "json" }},
+		{name: "invalid log format", modify: func(o *Options) { o.LogFormat = "xml" }, wantErr: "logFormat"},
+		{name: "stdout trace exporter", modify: func(o *Options) { o.TraceExporter = "stdout" }},
+		{name: "invalid trace exporter", modify: func(o *Options) { o.TraceExporter = "jaeger" }, wantErr: "traceExporter"},
+		{name: "app address", modify: func(o *Options) { o.AppAddress = "http://127.0.0.1:3000" }},
+		{name: "app address without scheme", modify: func(o *Options) { o.AppAddress = "127.0.0.1:3000" }, wantErr: "appAddress"},
+		{name: "app timeout is zero", modify: func(o *Options) { o.AppTimeout = 0 }, wantErr: "appTimeout"},
+		{name: "retry initial interval is zero", modify: func(o *Options) { o.RetryInitialInterval = 0 }, wantErr: "retryInitialInterval"},
+		{name: "retry max interval smaller than initial interval", modify: func(o *Options) { o.RetryMaxInterval = o.RetryInitialInterval / 2 }, wantErr: "retryMaxInterval"},
+		{name: "retry multiplier smaller than 1", modify: func(o *Options) { o.RetryMultiplier = 0.5 }, wantErr: "retryMultiplier"},
+		{name: "retry jitter greater than 1", modify: func(o *Options) { o.RetryJitter = 1.5 }, wantErr: "retryJitter"},
+		{name: "retry max attempts is negative", modify: func(o *Options) { o.RetryMaxAttempts = -1 }, wantErr: "retryMaxAttempts"},
+		{name: "unlimited retry attempts", modify: func(o *Options) { o.RetryMaxAttempts = 0 }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultOptions()
+			tt.modify(&opts)
+			err := opts.Validate()
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOptionsNewLogger(t *testing.T) {
+	t.Run("JSON format with debug level", func(t *testing.T) {
+		opts := DefaultOptions()
+		opts.LogLevel = "debug"
+		opts.LogFormat = "json"
+		var buf bytes.Buffer
+		opts.newLogger(&buf).Debug("hello", slog.String("key", "value"))
+
+		line := map[string]any{}
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+		assert.Equal(t, "hello", line["msg"])
+		assert.Equal(t, "DEBUG", line["level"])
+		assert.Equal(t, "value", line["key"])
+	})
+
+	t.Run("messages below the level are discarded", func(t *testing.T) {
+		opts := DefaultOptions()
+		opts.LogLevel = "warn"
+		var buf bytes.Buffer
+		logger := opts.newLogger(&buf)
+		logger.Info("hidden")
+		logger.Warn("shown")
+
+		assert.NotContains(t, buf.String(), "hidden")
+		assert.Contains(t, buf.String(), "level=WARN msg=shown")
+	})
+}
+
+func TestOptionsNewTracerProvider(t *testing.T) {
+	t.Run("spans are not exported by default", func(t *testing.T) {
+		tp, err := DefaultOptions().newTracerProvider(io.Discard)
+		require.NoError(t, err)
+		assert.Nil(t, tp)
+	})
+
+	t.Run("stdout exporter", func(t *testing.T) {
+		opts := DefaultOptions()
+		opts.TraceExporter = "stdout"
+		var buf bytes.Buffer
+		tp, err := opts.newTracerProvider(&buf)
+		require.NoError(t, err)
+		require.NotNil(t, tp)
+
+		_, span := tp.Tracer("test").Start(context.Background(), "test-span")
+		span.End()
+
+		// Spans are flushed on shutdown
+		require.NoError(t, tp.Shutdown(context.Background()))
+		assert.Contains(t, buf.String(), `"Name":"test-span"`)
+		assert.Contains(t, buf.String(), `"Value":"reminders-demo"`)
+	})
+}
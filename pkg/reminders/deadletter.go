@@ -0,0 +1,71 @@
+package reminders
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrDeadLetterNotFound is returned by ReminderStore.RequeueDeadLetter when there's no dead-lettered reminder with the given ID.
+	ErrDeadLetterNotFound = errors.New("dead-lettered reminder not found")
+	// ErrReminderExists is returned by ReminderStore.RequeueDeadLetter when a reminder with the same actor type, actor ID, and name exists already.
+	ErrReminderExists = errors.New("a reminder with the same actor type, actor ID, and name exists already")
+)
+
+// Maximum number of failed attempts kept in a reminder's history; older attempts are discarded
+const maxFailedAttempts = 20
+
+// FailedAttempt records a failed attempt to execute a reminder.
+type FailedAttempt struct {
+	// Number of the attempt, starting from 1
+	Attempt int `json:"attempt"`
+	// Time of the attempt
+	Time time.Time `json:"time"`
+	// Error returned by the execution
+	Error string `json:"error"`
+}
+
+// DeadLetter is a reminder that was moved to the dead-letter table because it failed to execute and exhausted its attempts.
+type DeadLetter struct {
+	// ID of the dead-lettered reminder, assigned by the store
+	ID int64
+	// Reminder with its original schedule; LeaseOwner, LeaseTime, Attempts, and RetryTime are not set
+	Reminder *Reminder
+	// Number of failed attempts
+	Attempts int
+	// Error returned by the last attempt
+	LastError string
+	// History of the most recent failed attempts, oldest first
+	FailedAttempts []FailedAttempt
+	// Time the reminder was moved to the dead-letter table
+	Time time.Time
+}
+
+// NewDeadLetter returns the DeadLetter object for a reminder that failed to execute for the last time at now with lastErr.
+// r must contain the history of the previous failed attempts.
+func NewDeadLetter(r *Reminder, attempts int, now time.Time, lastErr error) *DeadLetter {
+	original := *r
+	original.LeaseOwner = ""
+	original.LeaseTime = 0
+	original.Attempts = 0
+	original.RetryTime = time.Time{}
+	original.FailedAttempts = nil
+	return &DeadLetter{
+		Reminder:       &original,
+		Attempts:       attempts,
+		LastError:      lastErr.Error(),
+		FailedAttempts: appendFailedAttempt(r.FailedAttempts, FailedAttempt{Attempt: attempts, Time: now, Error: lastErr.Error()}),
+		Time:           now,
+	}
+}
+
+// Returns a new history with the failed attempt appended, keeping at most maxFailedAttempts.
+func appendFailedAttempt(history []FailedAttempt, a FailedAttempt) []FailedAttempt {
+	res := make([]FailedAttempt, 0, len(history)+1)
+	res = append(res, history...)
+	res = append(res, a)
+	if len(res) > maxFailedAttempts {
+		res = res[len(res)-maxFailedAttempts:]
+	}
+	return res
+}
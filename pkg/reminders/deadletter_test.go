@@ -0,0 +1,53 @@
+package reminders
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDeadLetter(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("original schedule without lease and retry state", func(t *testing.T) {
+		r := &Reminder{
+			ActorType:      "type",
+			ActorID:        "id",
+			Name:           "name",
+			ExecutionTime:  now,
+			Period:         time.Minute,
+			LeaseOwner:     "owner",
+			LeaseTime:      1000,
+			Attempts:       1,
+			RetryTime:      now.Add(time.Second),
+			FailedAttempts: []FailedAttempt{{Attempt: 1, Time: now, Error: "first"}},
+		}
+		dl := NewDeadLetter(r, 2, now.Add(time.Second), errors.New("second"))
+
+		assert.Equal(t, &Reminder{ActorType: "type", ActorID: "id", Name: "name", ExecutionTime: now, Period: time.Minute}, dl.Reminder)
+		assert.Equal(t, 2, dl.Attempts)
+		assert.Equal(t, "second", dl.LastError)
+		assert.Equal(t, now.Add(time.Second), dl.Time)
+		assert.Equal(t, []FailedAttempt{
+			{Attempt: 1, Time: now, Error: "first"},
+			{Attempt: 2, Time: now.Add(time.Second), Error: "second"},
+		}, dl.FailedAttempts)
+
+		// The reminder is not modified
+		assert.Len(t, r.FailedAttempts, 1)
+		assert.Equal(t, "owner", r.LeaseOwner)
+	})
+
+	t.Run("history keeps the most recent attempts", func(t *testing.T) {
+		history := []FailedAttempt{}
+		for i := 1; i <= maxFailedAttempts+5; i++ {
+			history = appendFailedAttempt(history, FailedAttempt{Attempt: i})
+		}
+		require.Len(t, history, maxFailedAttempts)
+		assert.Equal(t, 6, history[0].Attempt)
+		assert.Equal(t, maxFailedAttempts+5, history[maxFailedAttempts-1].Attempt)
+	})
+}
@@ -0,0 +1,90 @@
+package reminders
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrDeliveryFailed is returned by HTTPDelivery when the app did not accept the reminder.
+var ErrDeliveryFailed = errors.New("app did not accept the reminder")
+
+// Maximum number of bytes of the response body included in errors
+const maxErrorBodySize = 512
+
+// HTTPDelivery delivers reminders to the actor app over HTTP, using the same contract as Dapr: the app is invoked at "PUT /actors/{actorType}/{actorId}/method/remind/{reminderName}".
+type HTTPDelivery struct {
+	baseURL string
+	timeout time.Duration
+	client  *http.Client
+}
+
+// NewHTTPDelivery returns a new HTTPDelivery object that invokes the app at baseURL (e.g. "http://127.0.0.1:3000").
+// Requests that don't complete within timeout are canceled and treated as failures; if timeout is zero, there's no timeout.
+func NewHTTPDelivery(baseURL string, timeout time.Duration) *HTTPDelivery {
+	return &HTTPDelivery{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		timeout: timeout,
+		client:  &http.Client{},
+	}
+}
+
+// httpDeliveryBody is the body of the request sent to the app, in the format used by Dapr.
+type httpDeliveryBody struct {
+	Data    json.RawMessage `json:"data,omitempty"`
+	DueTime string          `json:"dueTime"`
+	Period  string          `json:"period"`
+}
+
+// Deliver invokes the app to deliver the reminder.
+// It returns an error if the request fails or times out, or if the app responds with a status code other than 2xx; in this case, the error wraps ErrDeliveryFailed.
+func (d *HTTPDelivery) Deliver(ctx context.Context, r *Reminder) error {
+	body := httpDeliveryBody{
+		Data:    r.Data,
+		DueTime: r.ExecutionTime.UTC().Format(time.RFC3339Nano),
+	}
+	if r.Period > 0 {
+		body.Period = r.Period.String()
+	} else if r.Cron != "" {
+		body.Period = r.Cron
+	}
+	enc, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to encode request body: %w", err)
+	}
+
+	if d.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.timeout)
+		defer cancel()
+	}
+
+	u := d.baseURL + "/actors/" + url.PathEscape(r.ActorType) + "/" + url.PathEscape(r.ActorID) + "/method/remind/" + url.PathEscape(r.Name)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u, bytes.NewReader(enc))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: failed to invoke app: %v", ErrDeliveryFailed, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		resBody, _ := io.ReadAll(io.LimitReader(res.Body, maxErrorBodySize))
+		return fmt.Errorf("%w: app responded with status code %d: %s", ErrDeliveryFailed, res.StatusCode, string(resBody))
+	}
+
+	// Drain the body so the connection can be reused
+	_, _ = io.Copy(io.Discard, res.Body)
+	return nil
+}
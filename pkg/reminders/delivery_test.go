@@ -0,0 +1,126 @@
+package reminders
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPDelivery(t *testing.T) {
+	type request struct {
+		method      string
+		path        string
+		contentType string
+		body        map[string]any
+	}
+
+	// Starts a stand-in app that records requests and responds with the status code returned by respond
+	startApp := func(t *testing.T, respond func(w http.ResponseWriter, r *http.Request)) (*httptest.Server, <-chan request) {
+		t.Helper()
+
+		reqCh := make(chan request, 1)
+		app := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body := map[string]any{}
+			data, _ := io.ReadAll(r.Body)
+			_ = json.Unmarshal(data, &body)
+			reqCh <- request{
+				method:      r.Method,
+				path:        r.URL.EscapedPath(),
+				contentType: r.Header.Get("Content-Type"),
+				body:        body,
+			}
+			respond(w, r)
+		}))
+		t.Cleanup(app.Close)
+		return app, reqCh
+	}
+
+	executionTime := time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	t.Run("reminder is delivered using the Dapr callback contract", func(t *testing.T) {
+		app, reqCh := startApp(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		r := &Reminder{
+			ActorType:     "myactor",
+			ActorID:       "a/b",
+			Name:          "myreminder",
+			ExecutionTime: executionTime,
+			Period:        10 * time.Second,
+			Data:          json.RawMessage(`{"hello":"world"}`),
+		}
+
+		err := NewHTTPDelivery(app.URL+"/", time.Second).Deliver(context.Background(), r)
+		require.NoError(t, err)
+
+		req := <-reqCh
+		assert.Equal(t, http.MethodPut, req.method)
+		assert.Equal(t, "/actors/myactor/a%2Fb/method/remind/myreminder", req.path)
+		assert.Equal(t, "application/json", req.contentType)
+		assert.Equal(t, map[string]any{
+			"data":    map[string]any{"hello": "world"},
+			"dueTime": "2023-01-01T10:00:00Z",
+			"period":  "10s",
+		}, req.body)
+	})
+
+	t.Run("reminder without data and period", func(t *testing.T) {
+		app, reqCh := startApp(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})
+		r := &Reminder{ActorType: "myactor", ActorID: "myid", Name: "once", ExecutionTime: executionTime}
+
+		err := NewHTTPDelivery(app.URL, time.Second).Deliver(context.Background(), r)
+		require.NoError(t, err)
+
+		req := <-reqCh
+		assert.Equal(t, map[string]any{
+			"dueTime": "2023-01-01T10:00:00Z",
+			"period":  "",
+		}, req.body)
+	})
+
+	t.Run("non-2xx status codes are failures", func(t *testing.T) {
+		app, _ := startApp(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("actor is busy"))
+		})
+		r := &Reminder{ActorType: "myactor", ActorID: "myid", Name: "fail", ExecutionTime: executionTime}
+
+		err := NewHTTPDelivery(app.URL, time.Second).Deliver(context.Background(), r)
+		require.ErrorIs(t, err, ErrDeliveryFailed)
+		assert.ErrorContains(t, err, "500")
+		assert.ErrorContains(t, err, "actor is busy")
+	})
+
+	t.Run("timeouts are failures", func(t *testing.T) {
+		unblockCh := make(chan struct{})
+		app, _ := startApp(t, func(w http.ResponseWriter, r *http.Request) {
+			<-unblockCh
+		})
+		defer close(unblockCh)
+		r := &Reminder{ActorType: "myactor", ActorID: "myid", Name: "slow", ExecutionTime: executionTime}
+
+		start := time.Now()
+		err := NewHTTPDelivery(app.URL, 50*time.Millisecond).Deliver(context.Background(), r)
+		require.ErrorIs(t, err, ErrDeliveryFailed)
+		assert.ErrorContains(t, err, "deadline exceeded")
+		assert.Less(t, time.Since(start), time.Second)
+	})
+
+	t.Run("app is not reachable", func(t *testing.T) {
+		app, _ := startApp(t, func(w http.ResponseWriter, r *http.Request) {})
+		app.Close()
+		r := &Reminder{ActorType: "myactor", ActorID: "myid", Name: "unreachable", ExecutionTime: executionTime}
+
+		err := NewHTTPDelivery(app.URL, time.Second).Deliver(context.Background(), r)
+		require.ErrorIs(t, err, ErrDeliveryFailed)
+	})
+}
@@ -0,0 +1,131 @@
+package reminders
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Matches ISO 8601 durations such as "P1DT2H" or "PT10.5S".
+// Years and months are matched so we can return a meaningful error, as they cannot be represented as a fixed duration.
+var iso8601DurationRegex = regexp.MustCompile(`^P(?:(\d+)Y)?(?:(\d+)M)?(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+// ParseDuration parses a duration that is either in the Go format (e.g. "1h30m") or in the ISO 8601 format (e.g. "PT1H30M").
+// ISO 8601 durations can use weeks, days, hours, minutes, and seconds; years and months are not supported.
+func ParseDuration(val string) (time.Duration, error) {
+	if strings.HasPrefix(val, "P") {
+		return parseISO8601Duration(val)
+	}
+
+	dur, err := time.ParseDuration(val)
+	if err != nil {
+		return 0, err
+	}
+	return dur, nil
+}
+
+// ParsePeriod parses the period of a repeating reminder.
+// The value can be a duration (see ParseDuration), or an ISO 8601 repeating interval in the format "R<n>/<duration>" (e.g. "R5/PT10S").
+// The returned repeats value is the number of times the reminder is executed, or 0 if unbounded.
+func ParsePeriod(val string) (period time.Duration, repeats int, err error) {
+	if strings.HasPrefix(val, "R") {
+		countStr, durStr, ok := strings.Cut(val[1:], "/")
+		if !ok {
+			return 0, 0, fmt.Errorf("invalid repeating interval '%s': missing duration", val)
+		}
+		// "R/<duration>" means repeating with no limit
+		if countStr != "" {
+			repeats, err = strconv.Atoi(countStr)
+			if err != nil || repeats <= 0 {
+				return 0, 0, fmt.Errorf("invalid repeating interval '%s': number of repetitions must be a positive integer", val)
+			}
+		}
+		val = durStr
+	}
+
+	period, err = ParseDuration(val)
+	if err != nil {
+		return 0, 0, err
+	}
+	if period <= 0 {
+		return 0, 0, errors.New("period must be greater than zero")
+	}
+	return period, repeats, nil
+}
+
+// ParseTime parses a time that can be in one of these formats:
+// - RFC3339 (e.g. "2023-01-02T15:04:05Z")
+// - A duration (see ParseDuration), interpreted as time from now; the duration can optionally be prefixed by "+" (e.g. "+10s")
+func ParseTime(val string, now time.Time) (time.Time, error) {
+	if val == "" {
+		return time.Time{}, errors.New("value is empty")
+	}
+
+	t, err := time.Parse(time.RFC3339, val)
+	if err == nil {
+		return t, nil
+	}
+
+	dur, durErr := ParseDuration(strings.TrimPrefix(val, "+"))
+	if durErr != nil {
+		return time.Time{}, fmt.Errorf("value '%s' is neither a RFC3339 time nor a duration", val)
+	}
+	return now.Add(dur), nil
+}
+
+func parseISO8601Duration(val string) (time.Duration, error) {
+	match := iso8601DurationRegex.FindStringSubmatch(val)
+	if match == nil || val == "P" || strings.HasSuffix(val, "T") {
+		return 0, fmt.Errorf("invalid ISO 8601 duration '%s'", val)
+	}
+	if match[1] != "" || match[2] != "" {
+		return 0, fmt.Errorf("invalid ISO 8601 duration '%s': years and months are not supported", val)
+	}
+
+	tooLarge := fmt.Errorf("invalid ISO 8601 duration '%s': value is too large", val)
+	var res time.Duration
+	units := []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute}
+	for i, unit := range units {
+		if match[i+3] == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(match[i+3], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid ISO 8601 duration '%s': %w", val, err)
+		}
+		// Reject values that do not fit in a time.Duration rather than letting them wrap around
+		if n > int64(math.MaxInt64/unit) {
+			return 0, tooLarge
+		}
+		res, err = addDuration(res, time.Duration(n)*unit)
+		if err != nil {
+			return 0, tooLarge
+		}
+	}
+
+	// Seconds can have a fractional part
+	if match[7] != "" {
+		secs, err := time.ParseDuration(match[7] + "s")
+		if err != nil {
+			return 0, fmt.Errorf("invalid ISO 8601 duration '%s': %w", val, err)
+		}
+		res, err = addDuration(res, secs)
+		if err != nil {
+			return 0, tooLarge
+		}
+	}
+
+	return res, nil
+}
+
+// Returns the sum of two non-negative durations, or an error if it overflows.
+func addDuration(a, b time.Duration) (time.Duration, error) {
+	if a > math.MaxInt64-b {
+		return 0, errors.New("duration overflows")
+	}
+	return a + b, nil
+}
@@ -0,0 +1,118 @@
+package reminders
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		val    string
+		expect time.Duration
+		err    bool
+	}{
+		{val: "10s", expect: 10 * time.Second},
+		{val: "1h30m", expect: 90 * time.Minute},
+		{val: "0", expect: 0},
+		{val: "PT10S", expect: 10 * time.Second},
+		{val: "PT0.5S", expect: 500 * time.Millisecond},
+		{val: "PT1H30M", expect: 90 * time.Minute},
+		{val: "P1DT2H", expect: 26 * time.Hour},
+		{val: "P2W", expect: 14 * 24 * time.Hour},
+		{val: "P15000W", expect: 15000 * 7 * 24 * time.Hour},
+		{val: "P300000W", err: true},
+		{val: "P15000WT1000000H", err: true},
+		{val: "P15000WT9000000000S", err: true},
+		{val: "P99999999999999999999D", err: true},
+		{val: "P1Y", err: true},
+		{val: "P1M", err: true},
+		{val: "P", err: true},
+		{val: "PT", err: true},
+		{val: "P1DT", err: true},
+		{val: "PT10", err: true},
+		{val: "10", err: true},
+		{val: "foo", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.val, func(t *testing.T) {
+			res, err := ParseDuration(tt.val)
+			if tt.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expect, res)
+		})
+	}
+}
+
+func TestParsePeriod(t *testing.T) {
+	tests := []struct {
+		val           string
+		expectPeriod  time.Duration
+		expectRepeats int
+		err           bool
+	}{
+		{val: "10s", expectPeriod: 10 * time.Second},
+		{val: "PT10S", expectPeriod: 10 * time.Second},
+		{val: "R5/PT10S", expectPeriod: 10 * time.Second, expectRepeats: 5},
+		{val: "R5/10s", expectPeriod: 10 * time.Second, expectRepeats: 5},
+		{val: "R/PT1M", expectPeriod: time.Minute},
+		{val: "R0/PT10S", err: true},
+		{val: "R-1/PT10S", err: true},
+		{val: "R5", err: true},
+		{val: "R5/", err: true},
+		{val: "0s", err: true},
+		{val: "PT0S", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.val, func(t *testing.T) {
+			period, repeats, err := ParsePeriod(tt.val)
+			if tt.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectPeriod, period)
+			assert.Equal(t, tt.expectRepeats, repeats)
+		})
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		val    string
+		expect time.Time
+		err    bool
+	}{
+		{val: "2023-02-02T02:02:02Z", expect: time.Date(2023, 2, 2, 2, 2, 2, 0, time.UTC)},
+		{val: "+1m30s", expect: now.Add(90 * time.Second)},
+		{val: "1m30s", expect: now.Add(90 * time.Second)},
+		{val: "PT1M30S", expect: now.Add(90 * time.Second)},
+		{val: "+PT1M30S", expect: now.Add(90 * time.Second)},
+		{val: "0s", expect: now},
+		{val: "", err: true},
+		{val: "+foo", err: true},
+		{val: "tomorrow", err: true},
+		{val: "R5/PT10S", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.val, func(t *testing.T) {
+			res, err := ParseTime(tt.val, now)
+			if tt.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, tt.expect.Equal(res), "expected %v, got %v", tt.expect, res)
+		})
+	}
+}
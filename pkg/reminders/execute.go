@@ -0,0 +1,196 @@
+package reminders
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	kclock "k8s.io/utils/clock"
+)
+
+var (
+	// ErrReminderExpired is returned by ReminderStore.ExecuteReminder when the reminder's TTL has expired; the reminder is removed without being executed.
+	ErrReminderExpired = errors.New("reminder has expired")
+	// ErrLeaseLostDuringExecution is returned by ReminderStore.ExecuteReminder when the lease was lost while the reminder was being executed, so it may be executed again.
+	ErrLeaseLostDuringExecution = errors.New("lease was lost while the reminder was being executed, so it may be executed again")
+)
+
+// CanceledError is returned by ReminderStore.ExecuteReminder when the context was canceled while the reminder was being executed, e.g. because the caller is shutting down.
+// The canceled execution does not count as a failed attempt, and the reminder is left in the store with its lease.
+type CanceledError struct {
+	// Reminder with the current lease token, which the caller can use to release the lease
+	Reminder *Reminder
+	// Error returned by the execution, which wraps the context's error
+	Err error
+}
+
+// Error implements the error interface.
+func (e *CanceledError) Error() string {
+	return "execution was canceled: " + e.Err.Error()
+}
+
+// Unwrap returns the error returned by the execution.
+func (e *CanceledError) Unwrap() error {
+	return e.Err
+}
+
+// ExecuteFn is the callback that runs a reminder, invoked by ReminderStore.ExecuteReminder.
+// The context is canceled if the lease on the reminder is lost while the callback is running, or if the caller is shutting down.
+// Callbacks must honor the context: when it's canceled, they should stop and return its error, so the reminder is not completed and its lease can be released.
+// Returning an error means the execution failed, and the reminder is not completed; returning nil means the reminder was executed, and it's completed even if the context was canceled in the meanwhile.
+type ExecuteFn func(ctx context.Context) error
+
+// ExecuteRequest contains the parameters for ReminderStore.ExecuteReminder.
+type ExecuteRequest struct {
+	// For stores that rely on leases, how often to renew the lease while the reminder is being executed
+	LeaseRenewInterval time.Duration
+	// Clock used to determine the current time
+	Clock kclock.Clock
+	// If not nil, reminders that fail to execute are scheduled to be retried according to this policy
+	// Otherwise, they are left in the store and picked up again after their lease expires
+	RetryPolicy *RetryPolicy
+	// Retries scheduled within this interval keep the lease, so the caller can retry the reminder itself; later retries release the lease
+	MaxLocalRetryDelay time.Duration
+}
+
+// ExecuteWithLeaseRenewal implements ReminderStore.ExecuteReminder for stores that cannot hold a transaction open while the reminder is executed.
+// The lease is renewed before invoking executeFn, which confirms the reminder is still owned by the caller, and then every req.LeaseRenewInterval until executeFn returns.
+// If executeFn succeeds, the reminder is completed with CompleteReminder.
+// If executeFn fails and req.RetryPolicy is set, a retry is scheduled with RetryReminder and a *RetryError is returned; once the maximum number of attempts is reached, the reminder is moved to the dead-letter table with DeadLetterReminder and the error wraps ErrRetriesExhausted.
+// Without a retry policy, a reminder that fails is left in the store and it will be picked up again after its lease expires.
+// If ctx is canceled while executeFn is running and executeFn returns an error, the reminder is left in the store with its lease, and a *CanceledError is returned.
+// The store calls made after executeFn returns don't use ctx, so the outcome of an execution that completes while ctx is canceled is still recorded.
+func ExecuteWithLeaseRenewal(ctx context.Context, store ReminderStore, r *Reminder, req ExecuteRequest, executeFn ExecuteFn) error {
+	now := req.Clock.Now()
+
+	// If the reminder's TTL has expired, remove it from the store without executing it
+	if r.Expired(now) {
+		err := store.CompleteReminder(ctx, r, nil)
+		if err != nil {
+			return err
+		}
+		return ErrReminderExpired
+	}
+
+	// Renew the lease before executing the reminder, which also confirms that we still own it
+	// We work on a copy of the reminder because the lease token changes every time the lease is renewed
+	leased := *r
+	var err error
+	leased.LeaseTime, err = store.RenewLease(ctx, &leased, now)
+	if err != nil {
+		return err
+	}
+
+	// Execute the reminder, without holding a transaction open
+	// While the reminder is executing, keep renewing the lease in background; if the lease is lost, the context is canceled
+	execCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stopCh := make(chan struct{})
+	renewErrCh := make(chan error, 1)
+	go func() {
+		err := renewLeaseLoop(execCtx, store, &leased, req, stopCh)
+		if err != nil {
+			cancel()
+		}
+		renewErrCh <- err
+	}()
+	execErr := executeFn(execCtx)
+	close(stopCh)
+	err = <-renewErrCh
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrLeaseLostDuringExecution, err)
+	}
+
+	// The outcome is recorded with a context that is not canceled together with ctx, because executeFn may have completed while the caller is shutting down
+	// Otherwise, the reminder would keep its lease, and it would be executed again once the lease expires
+	storeCtx, storeCancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer storeCancel()
+
+	if execErr != nil {
+		// If the execution was canceled by the caller (e.g. while shutting down), it does not count as a failed attempt
+		// The reminder keeps its lease, and the error contains the current lease token so the caller can release it
+		if ctx.Err() != nil {
+			return &CanceledError{Reminder: &leased, Err: fmt.Errorf("%w: %w", ctx.Err(), execErr)}
+		}
+		return retryReminder(storeCtx, store, r, &leased, req, execErr)
+	}
+
+	// Remove the reminder from the store (or, if it repeats, reschedule it to the next iteration)
+	err = store.CompleteReminder(storeCtx, &leased, r.NextIteration(now))
+	if errors.Is(err, ErrLeaseLost) {
+		return fmt.Errorf("%w: %v", ErrLeaseLostDuringExecution, err)
+	} else if err != nil {
+		return fmt.Errorf("failed to complete reminder after it was executed, so it may be executed again: %w", err)
+	}
+	return nil
+}
+
+// Schedules a reminder that failed to execute to be retried according to req.RetryPolicy.
+// r is the reminder as it was acquired, and leased contains the current lease token.
+// ctx is used for the store calls, so it must not be the one that was passed to executeFn.
+func retryReminder(ctx context.Context, store ReminderStore, r *Reminder, leased *Reminder, req ExecuteRequest, execErr error) error {
+	if req.RetryPolicy == nil {
+		return fmt.Errorf("failed to execute reminder: %w", execErr)
+	}
+
+	now := req.Clock.Now()
+	attempts := leased.Attempts + 1
+
+	// If there are no attempts left, move the reminder to the dead-letter table, with the history of the failed attempts
+	if req.RetryPolicy.Exhausted(attempts) {
+		err := store.DeadLetterReminder(ctx, leased, NewDeadLetter(leased, attempts, now, execErr))
+		if errors.Is(err, ErrLeaseLost) {
+			return fmt.Errorf("%w: %v", ErrLeaseLostDuringExecution, err)
+		} else if err != nil {
+			return fmt.Errorf("failed to move reminder to the dead-letter table after it exhausted its attempts: %w", err)
+		}
+		return fmt.Errorf("%w after %d attempts, so it was moved to the dead-letter table: %w", ErrRetriesExhausted, attempts, execErr)
+	}
+
+	// If the retry is due soon, keep the lease so the caller can retry the reminder without waiting for it to be acquired again
+	retry := *leased
+	retry.Attempts = attempts
+	retry.RetryTime = now.Add(req.RetryPolicy.Delay(attempts))
+	retry.FailedAttempts = appendFailedAttempt(leased.FailedAttempts, FailedAttempt{Attempt: attempts, Time: now, Error: execErr.Error()})
+	if retry.RetryTime.Sub(now) <= req.MaxLocalRetryDelay {
+		retry.LeaseTime = now.UnixMilli()
+	} else {
+		retry.LeaseOwner = ""
+		retry.LeaseTime = 0
+	}
+	err := store.RetryReminder(ctx, leased, &retry)
+	if errors.Is(err, ErrLeaseLost) {
+		return fmt.Errorf("%w: %v", ErrLeaseLostDuringExecution, err)
+	} else if err != nil {
+		return fmt.Errorf("failed to schedule retry after the reminder failed to execute: %w", err)
+	}
+	return &RetryError{Reminder: &retry, Err: execErr}
+}
+
+// Renews the lease on a reminder periodically until stopCh is closed or ctx is canceled, updating the reminder's LeaseTime.
+// Returns an error if the lease could not be renewed.
+func renewLeaseLoop(ctx context.Context, store ReminderStore, r *Reminder, req ExecuteRequest, stopCh <-chan struct{}) error {
+	for {
+		t := req.Clock.NewTimer(req.LeaseRenewInterval)
+		select {
+		case <-stopCh:
+			t.Stop()
+			return nil
+		case <-ctx.Done():
+			// The caller is shutting down: the lease is not lost, and it keeps the last token
+			t.Stop()
+			return nil
+		case <-t.C():
+			leaseTime, err := store.RenewLease(ctx, r, req.Clock.Now())
+			if err != nil {
+				// If ctx was canceled while renewing the lease, the renewal failed because of that
+				if ctx.Err() != nil {
+					return nil
+				}
+				return err
+			}
+			r.LeaseTime = leaseTime
+		}
+	}
+}
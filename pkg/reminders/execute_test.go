@@ -0,0 +1,111 @@
+package reminders
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func TestExecuteWithLeaseRenewal(t *testing.T) {
+	store := NewSQLiteStore(newTestDB(t), nil, nil)
+	clock := clocktesting.NewFakeClock(time.Now())
+	req := ExecuteRequest{
+		LeaseRenewInterval: 10 * time.Second,
+		Clock:              clock,
+	}
+
+	// Saves a reminder that is due now and acquires it
+	acquire := func(t *testing.T, name string) *Reminder {
+		t.Helper()
+
+		require.NoError(t, store.SaveReminder(context.Background(), &Reminder{ActorType: "type", ActorID: "id", Name: name, ExecutionTime: clock.Now()}))
+		acquired, err := store.AcquireReminders(context.Background(), AcquireRequest{
+			Now:           clock.Now(),
+			Owner:         "owner",
+			FetchAhead:    5 * time.Second,
+			LeaseDuration: time.Minute,
+			BatchSize:     10,
+		})
+		require.NoError(t, err)
+		require.Len(t, acquired, 1)
+		return acquired[0]
+	}
+
+	t.Run("reminder is completed if the execution succeeds after the context is canceled", func(t *testing.T) {
+		r := acquire(t, "completed")
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		// The callback does not honor the context, and it completes after it was canceled
+		err := ExecuteWithLeaseRenewal(ctx, store, r, req, func(execCtx context.Context) error {
+			cancel()
+			return nil
+		})
+		require.NoError(t, err)
+
+		// The reminder was removed, so it's not executed again
+		deleted, err := store.DeleteReminder(context.Background(), r)
+		require.NoError(t, err)
+		assert.False(t, deleted)
+	})
+
+	t.Run("lease renewal after the context is canceled", func(t *testing.T) {
+		r := acquire(t, "canceled")
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		startedCh := make(chan struct{})
+		returnCh := make(chan struct{})
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- ExecuteWithLeaseRenewal(ctx, store, r, req, func(execCtx context.Context) error {
+				close(startedCh)
+				<-returnCh
+				return execCtx.Err()
+			})
+		}()
+		<-startedCh
+
+		// Renew the lease once, so its token changes
+		assert.Eventually(t, clock.HasWaiters, time.Second, 10*time.Millisecond)
+		clock.Step(req.LeaseRenewInterval)
+		assert.Eventually(t, func() bool {
+			leases, err := store.ListLeases(context.Background())
+			return err == nil && len(leases) == 1 && leases[0].LeaseTime != r.LeaseTime
+		}, time.Second, 10*time.Millisecond)
+
+		// The next renewal is due after the context is canceled
+		assert.Eventually(t, clock.HasWaiters, time.Second, 10*time.Millisecond)
+		cancel()
+		clock.Step(req.LeaseRenewInterval)
+		close(returnCh)
+
+		var err error
+		select {
+		case err = <-errCh:
+		case <-time.After(time.Second):
+			t.Fatal("execution did not return in 1s")
+		}
+
+		// The lease was not lost, and the error contains its current token
+		require.NotErrorIs(t, err, ErrLeaseLostDuringExecution)
+		var canceledErr *CanceledError
+		require.True(t, errors.As(err, &canceledErr), "unexpected error: %v", err)
+		require.ErrorIs(t, err, context.Canceled)
+		leases, err := store.ListLeases(context.Background())
+		require.NoError(t, err)
+		require.Len(t, leases, 1)
+		assert.Equal(t, leases[0].LeaseTime, canceledErr.Reminder.LeaseTime)
+
+		// The token can be used to release the lease
+		require.NoError(t, store.ReleaseLeases(context.Background(), []*Reminder{canceledErr.Reminder}))
+		leases, err = store.ListLeases(context.Background())
+		require.NoError(t, err)
+		assert.Empty(t, leases)
+	})
+}
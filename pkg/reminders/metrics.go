@@ -0,0 +1,139 @@
+package reminders
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Values for the "stage" label of the lost leases counter
+const (
+	// Lease was lost before the reminder was executed, so it was not executed
+	LostLeaseBeforeExecution = "before_execution"
+	// Lease was lost while the reminder was being executed, or before it could be completed, so it may be executed again
+	LostLeaseDuringExecution = "during_execution"
+)
+
+// Metrics contains the Prometheus metrics for reminders.
+// All methods are safe to invoke on a nil object, which does not record anything.
+type Metrics struct {
+	queueLengthFn     atomic.Pointer[func() int]
+	leasedPerPoll     prometheus.Histogram
+	lostLeases        *prometheus.CounterVec
+	executionLag      prometheus.Histogram
+	executionDuration prometheus.Histogram
+	deadLettered      prometheus.Counter
+	queryDuration     *prometheus.HistogramVec
+}
+
+// NewMetrics returns a new Metrics object, registering the metrics with reg.
+func NewMetrics(reg prometheus.Registerer) (*Metrics, error) {
+	m := &Metrics{
+		leasedPerPoll: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "reminders",
+			Name:      "leased_per_poll",
+			Help:      "Number of reminders leased each time the database is polled.",
+			Buckets:   []float64{0, 1, 2, 5, 10, 20, 50, 100, 200, 500, 1000},
+		}),
+		lostLeases: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "reminders",
+			Name:      "lost_leases_total",
+			Help:      "Number of reminders whose lease was lost, by stage of the execution.",
+		}, []string{"stage"}),
+		executionLag: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "reminders",
+			Name:      "execution_lag_seconds",
+			Help:      "Delay between the time a reminder is scheduled for and the time it's executed.",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 2, 14),
+		}),
+		executionDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "reminders",
+			Name:      "execution_duration_seconds",
+			Help:      "Time taken to execute reminders.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		deadLettered: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "reminders",
+			Name:      "dead_lettered_total",
+			Help:      "Number of reminders moved to the dead-letter table after exhausting their attempts.",
+		}),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "reminders",
+			Name:      "db_query_duration_seconds",
+			Help:      "Latency of the operations on the database, by operation.",
+			Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+		}, []string{"operation"}),
+	}
+
+	queueLength := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "reminders",
+		Name:      "queue_length",
+		Help:      "Number of reminders in the in-memory queue.",
+	}, func() float64 {
+		fn := m.queueLengthFn.Load()
+		if fn == nil {
+			return 0
+		}
+		return float64((*fn)())
+	})
+
+	collectors := []prometheus.Collector{queueLength, m.leasedPerPoll, m.lostLeases, m.executionLag, m.executionDuration, m.deadLettered, m.queryDuration}
+	for _, c := range collectors {
+		err := reg.Register(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// SetQueueLengthFunc sets the function that returns the length of the in-memory queue.
+func (m *Metrics) SetQueueLengthFunc(fn func() int) {
+	if m == nil {
+		return
+	}
+	m.queueLengthFn.Store(&fn)
+}
+
+// ObserveLeased records the number of reminders that were leased in a poll.
+func (m *Metrics) ObserveLeased(n int) {
+	if m == nil {
+		return
+	}
+	m.leasedPerPoll.Observe(float64(n))
+}
+
+// IncLostLeases increments the count of lost leases; stage is LostLeaseBeforeExecution or LostLeaseDuringExecution.
+func (m *Metrics) IncLostLeases(stage string) {
+	if m == nil {
+		return
+	}
+	m.lostLeases.WithLabelValues(stage).Inc()
+}
+
+// ObserveExecution records the lag and the duration of the execution of a reminder.
+func (m *Metrics) ObserveExecution(lag time.Duration, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.executionLag.Observe(lag.Seconds())
+	m.executionDuration.Observe(duration.Seconds())
+}
+
+// IncDeadLettered increments the count of reminders moved to the dead-letter table.
+func (m *Metrics) IncDeadLettered() {
+	if m == nil {
+		return
+	}
+	m.deadLettered.Inc()
+}
+
+// ObserveQuery records the latency of an operation on the database, which started at start.
+// This is meant to be invoked with defer.
+func (m *Metrics) ObserveQuery(operation string, start time.Time) {
+	if m == nil {
+		return
+	}
+	m.queryDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,110 @@
+package reminders
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Run("nil metrics do not record anything", func(t *testing.T) {
+		var m *Metrics
+		assert.NotPanics(t, func() {
+			m.SetQueueLengthFunc(func() int { return 1 })
+			m.ObserveLeased(1)
+			m.IncLostLeases(LostLeaseBeforeExecution)
+			m.ObserveExecution(time.Second, time.Second)
+			m.IncDeadLettered()
+			m.ObserveQuery("save", time.Now())
+		})
+	})
+
+	t.Run("metrics cannot be registered twice", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		_, err := NewMetrics(reg)
+		require.NoError(t, err)
+		_, err = NewMetrics(reg)
+		require.Error(t, err)
+	})
+
+	t.Run("queue length is read when collected", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		m, err := NewMetrics(reg)
+		require.NoError(t, err)
+
+		expected := func(n string) string {
+			return `
+# HELP reminders_queue_length Number of reminders in the in-memory queue.
+# TYPE reminders_queue_length gauge
+reminders_queue_length ` + n + "\n"
+		}
+		require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected("0")), "reminders_queue_length"))
+
+		queued := 3
+		m.SetQueueLengthFunc(func() int { return queued })
+		require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected("3")), "reminders_queue_length"))
+		queued = 5
+		require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected("5")), "reminders_queue_length"))
+	})
+
+	t.Run("lost leases are counted by stage", func(t *testing.T) {
+		m, err := NewMetrics(prometheus.NewRegistry())
+		require.NoError(t, err)
+
+		m.IncLostLeases(LostLeaseBeforeExecution)
+		m.IncLostLeases(LostLeaseDuringExecution)
+		m.IncLostLeases(LostLeaseDuringExecution)
+		assert.Equal(t, 1.0, testutil.ToFloat64(m.lostLeases.WithLabelValues(LostLeaseBeforeExecution)))
+		assert.Equal(t, 2.0, testutil.ToFloat64(m.lostLeases.WithLabelValues(LostLeaseDuringExecution)))
+	})
+
+	t.Run("SQLite store records query latencies and leased reminders", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		m, err := NewMetrics(reg)
+		require.NoError(t, err)
+
+		store := NewSQLiteStore(newTestDB(t), m, nil)
+		ctx := context.Background()
+		now := time.Now()
+		require.NoError(t, store.SaveReminder(ctx, &Reminder{ActorType: "type", ActorID: "id", Name: "r1", ExecutionTime: now}))
+		require.NoError(t, store.SaveReminder(ctx, &Reminder{ActorType: "type", ActorID: "id", Name: "r2", ExecutionTime: now}))
+		acquired, err := store.AcquireReminders(ctx, AcquireRequest{
+			Now:           now,
+			Owner:         "owner1",
+			FetchAhead:    5 * time.Second,
+			LeaseDuration: 30 * time.Second,
+			BatchSize:     10,
+		})
+		require.NoError(t, err)
+		require.Len(t, acquired, 2)
+
+		// One series for each operation
+		assert.Equal(t, 2, testutil.CollectAndCount(m.queryDuration))
+
+		err = testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP reminders_leased_per_poll Number of reminders leased each time the database is polled.
+# TYPE reminders_leased_per_poll histogram
+reminders_leased_per_poll_bucket{le="0"} 0
+reminders_leased_per_poll_bucket{le="1"} 0
+reminders_leased_per_poll_bucket{le="2"} 1
+reminders_leased_per_poll_bucket{le="5"} 1
+reminders_leased_per_poll_bucket{le="10"} 1
+reminders_leased_per_poll_bucket{le="20"} 1
+reminders_leased_per_poll_bucket{le="50"} 1
+reminders_leased_per_poll_bucket{le="100"} 1
+reminders_leased_per_poll_bucket{le="200"} 1
+reminders_leased_per_poll_bucket{le="500"} 1
+reminders_leased_per_poll_bucket{le="1000"} 1
+reminders_leased_per_poll_bucket{le="+Inf"} 1
+reminders_leased_per_poll_sum 2
+reminders_leased_per_poll_count 1
+`), "reminders_leased_per_poll")
+		require.NoError(t, err)
+	})
+}
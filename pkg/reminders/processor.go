@@ -1,7 +1,10 @@
 package reminders
 
 import (
+	"context"
 	"errors"
+	"log/slog"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -14,31 +17,117 @@ var ErrProcessorStopped = errors.New("processor is stopped")
 
 // Processor manages the queue of items and processes them at the correct time.
 type Processor[T queueable] struct {
-	executeFn          func(r T)
+	executeFn          func(ctx context.Context, r T) error
+	onResult           func(res ExecutionResult[T])
 	queue              *Queue[T]
 	clock              kclock.Clock
+	logger             *slog.Logger
 	queueLock          sync.Mutex
 	processorRunningCh chan struct{}
 	stopCh             chan struct{}
 	resetCh            chan struct{}
 	stopped            atomic.Bool
+
+	// Context passed to executeFn, which is canceled when the processor is stopped
+	ctx    context.Context
+	cancel context.CancelFunc
+	// Executions that are in progress
+	executing sync.WaitGroup
+
+	// Worker pool, which limits the number of concurrent executions
+	// When both locks are held, queueLock must be acquired before poolLock
+	opts      ProcessorOptions[T]
+	poolLock  sync.Mutex
+	pending   []T
+	running   int
+	perGroup  map[string]int
+	hasRoomCh chan struct{}
+}
+
+// SaturationPolicy determines what the processor does with due items when it's saturated, i.e. when no worker is available for them and the pending buffer is full.
+type SaturationPolicy int
+
+const (
+	// SaturationWait makes due items wait in the queue until there's room for them
+	// Items that cannot start only because their group is saturated are rejected instead, so they don't hold back the items of other groups
+	SaturationWait SaturationPolicy = iota
+	// SaturationReject removes due items from the queue and passes them to ProcessorOptions.OnRejected
+	SaturationReject
+)
+
+// ProcessorOptions contains the optional parameters for NewProcessorWithContext.
+type ProcessorOptions[T queueable] struct {
+	// Logger; if nil, the default logger is used
+	Logger *slog.Logger
+	// If not nil, invoked after each execution with its outcome, in the same goroutine as the execution
+	OnResult func(res ExecutionResult[T])
+
+	// Maximum number of items executed concurrently; 0 means no limit
+	MaxConcurrent int
+	// Maximum number of items of the same group executed concurrently; 0 means no limit
+	// Requires GroupFn, which returns the group of an item (e.g. the actor type)
+	MaxConcurrentPerGroup int
+	GroupFn               func(r T) string
+	// Maximum number of due items waiting for a worker
+	MaxPending int
+	// What to do with due items when the processor is saturated
+	Saturation SaturationPolicy
+	// Invoked with the items that are rejected: with SaturationReject, all items for which there's no room; with SaturationWait, items whose group is saturated when the pending buffer is full
+	// This is invoked by the processing loop, so it must not block
+	OnRejected func(r T)
+}
+
+// ExecutionResult is the outcome of the execution of an item, which is reported to ProcessorOptions.OnResult.
+type ExecutionResult[T queueable] struct {
+	// Item that was executed
+	Item T
+	// Error returned by executeFn, or nil if the execution succeeded
+	Err error
+	// Delay between the time the item was scheduled for and the time it was executed
+	Lag time.Duration
+	// Time taken by executeFn
+	Duration time.Duration
 }
 
 // NewProcessor returns a new Processor object.
 // executeFn is the callback invoked when the item is to be executed; this will be invoked in a background goroutine.
+// The processor logs to the default logger; use NewProcessorWithContext to set a different one.
 func NewProcessor[T queueable](executeFn func(r T), clock kclock.Clock) *Processor[T] {
+	return NewProcessorWithContext(func(ctx context.Context, r T) error {
+		executeFn(r)
+		return nil
+	}, clock, ProcessorOptions[T]{})
+}
+
+// NewProcessorWithContext returns a new Processor object whose executeFn receives a context and returns an error.
+// executeFn is invoked in a background goroutine when the item is to be executed; its context is canceled when the processor is stopped.
+// The outcome of each execution is reported to opts.OnResult, if set.
+// If opts sets a concurrency limit, due items wait in a pending buffer until a worker is available; when the buffer is full, opts.Saturation determines whether they wait in the queue or are rejected.
+func NewProcessorWithContext[T queueable](executeFn func(ctx context.Context, r T) error, clock kclock.Clock, opts ProcessorOptions[T]) *Processor[T] {
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Processor[T]{
 		executeFn:          executeFn,
+		onResult:           opts.OnResult,
 		queue:              NewQueue[T](),
 		processorRunningCh: make(chan struct{}, 1),
 		stopCh:             make(chan struct{}),
 		resetCh:            make(chan struct{}, 1),
 		clock:              clock,
+		logger:             logger,
+		ctx:                ctx,
+		cancel:             cancel,
+		opts:               opts,
+		perGroup:           map[string]int{},
+		hasRoomCh:          make(chan struct{}, 1),
 	}
 }
 
 // Enqueue adds a new item to the queue.
-// If a item with the same ID already exists, it'll be replaced.
+// If a item with the same ID already exists, it'll be replaced, even if it's due and waiting for a worker.
 func (p *Processor[T]) Enqueue(r T) error {
 	if p.stopped.Load() {
 		return ErrProcessorStopped
@@ -52,13 +141,17 @@ func (p *Processor[T]) Enqueue(r T) error {
 	p.queue.Insert(r, true)
 	peek, _ = p.queue.Peek()         // No need to check for "ok" here because we know this will return an item
 	isFirst = isFirst || (peek == r) // This is also going to be true if the item just added landed at the front of the queue
+	// If the item being replaced is due and waiting for a worker, remove it
+	p.poolLock.Lock()
+	p.removePending(r.Key())
+	p.poolLock.Unlock()
 	p.process(isFirst)
 	p.queueLock.Unlock()
 
 	return nil
 }
 
-// Dequeue removes a item from the queue.
+// Dequeue removes a item from the queue, even if it's due and waiting for a worker.
 func (p *Processor[T]) Dequeue(r T) error {
 	if p.stopped.Load() {
 		return ErrProcessorStopped
@@ -72,12 +165,87 @@ func (p *Processor[T]) Dequeue(r T) error {
 		// If the item was the first one in the queue, restart the processor
 		p.process(true)
 	}
+	// The item may also be due and waiting for a worker
+	p.poolLock.Lock()
+	p.removePending(r.Key())
+	p.poolLock.Unlock()
 	p.queueLock.Unlock()
 
 	return nil
 }
 
+// DequeueFunc removes all items for which fn returns true from the queue, including those that are due and waiting for a worker, and returns them.
+// Items that are being executed are not affected.
+func (p *Processor[T]) DequeueFunc(fn func(r T) bool) ([]T, error) {
+	if p.stopped.Load() {
+		return nil, ErrProcessorStopped
+	}
+
+	// If the first item in the queue is removed, restart the processor
+	p.queueLock.Lock()
+	peek, ok := p.queue.Peek()
+	res := p.queue.RemoveFunc(fn)
+	if ok && slices.ContainsFunc(res, func(r T) bool { return r.Key() == peek.Key() }) {
+		p.process(true)
+	}
+
+	p.poolLock.Lock()
+	kept := p.pending[:0]
+	for _, r := range p.pending {
+		if fn(r) {
+			res = append(res, r)
+		} else {
+			kept = append(kept, r)
+		}
+	}
+	clear(p.pending[len(kept):])
+	p.pending = kept
+	p.poolLock.Unlock()
+	p.queueLock.Unlock()
+
+	return res, nil
+}
+
+// Len returns the number of items in the queue, including those that are due and waiting for a worker.
+func (p *Processor[T]) Len() int {
+	p.queueLock.Lock()
+	n := p.queue.Len()
+	p.queueLock.Unlock()
+
+	p.poolLock.Lock()
+	n += len(p.pending)
+	p.poolLock.Unlock()
+	return n
+}
+
+// Executing returns the number of items that are being executed.
+func (p *Processor[T]) Executing() int {
+	p.poolLock.Lock()
+	defer p.poolLock.Unlock()
+	return p.running
+}
+
+// Drain removes all items from the queue, including those that are due and waiting for a worker, and returns them.
+// This is meant to be invoked after the processor has been stopped, to retrieve the items that were not processed.
+func (p *Processor[T]) Drain() []T {
+	p.poolLock.Lock()
+	res := p.pending
+	p.pending = nil
+	p.poolLock.Unlock()
+
+	p.queueLock.Lock()
+	defer p.queueLock.Unlock()
+	for {
+		r, ok := p.queue.Pop()
+		if !ok {
+			return res
+		}
+		res = append(res, r)
+	}
+}
+
 // Stop the processor.
+// The context of the executions that are in progress is canceled, and this waits for them to return.
 func (p *Processor[T]) Close() error {
 	if !p.stopped.CompareAndSwap(false, true) {
 		// Already stopped
@@ -89,16 +257,29 @@ func (p *Processor[T]) Close() error {
 
 	// Blocks until process loop ends
 	t := time.NewTimer(5 * time.Second)
+	defer t.Stop()
 	select {
 	case p.processorRunningCh <- struct{}{}:
 		// All good
-		if !t.Stop() {
-			<-t.C
-		}
 	case <-t.C:
 		return errors.New("Processor loop did not stop in 5s")
 	}
 
+	// Cancel the executions that are in progress and wait for them to return
+	// The process loop has ended, so no new execution can start
+	p.cancel()
+	doneCh := make(chan struct{})
+	go func() {
+		p.executing.Wait()
+		close(doneCh)
+	}()
+	select {
+	case <-doneCh:
+		// All good
+	case <-t.C:
+		return errors.New("Executions in progress did not return in 5s")
+	}
+
 	return nil
 }
 
@@ -196,6 +377,11 @@ func (p *Processor[T]) processLoop() {
 
 // Executes a item when it's time.
 func (p *Processor[T]) execute(r T) {
+	// If the processor is saturated, wait until there's room for the item
+	if p.opts.Saturation == SaturationWait && !p.waitForRoom(r) {
+		return
+	}
+
 	// Pop the item now that we're ready to process it
 	// There's a small chance this is a different item than the one we peeked before
 	p.queueLock.Lock()
@@ -207,10 +393,142 @@ func (p *Processor[T]) execute(r T) {
 		return
 	}
 	r, ok = p.queue.Pop()
-	p.queueLock.Unlock()
 	if !ok {
+		p.queueLock.Unlock()
+		return
+	}
+
+	// Add the item to the pending buffer, and start it right away if a worker is available
+	// If there's no room for the item, it's rejected: with SaturationWait, this happens only if the item's group is saturated, as we waited for a worker above
+	// poolLock is acquired before releasing queueLock, so the item can't be missed by Dequeue while it's moved to the pending buffer
+	p.poolLock.Lock()
+	p.queueLock.Unlock()
+	if !p.hasRoom(r) {
+		p.poolLock.Unlock()
+		p.logger.Warn("Processor is saturated, rejecting item", slog.Any("item", r))
+		if p.opts.OnRejected != nil {
+			p.opts.OnRejected(r)
+		}
+		return
+	}
+	p.logger.Debug("Processing item", slog.Any("item", r), slog.Duration("lag", p.clock.Since(r.ScheduledTime())))
+	p.pending = append(p.pending, r)
+	p.dispatch()
+	p.poolLock.Unlock()
+}
+
+// Waits until there's room for the item, returning false if the processor is stopped or reset in the meanwhile.
+// This only waits while all workers are busy: if a worker is available but the item's group is saturated and the pending buffer is full, it returns right away so the item is rejected, rather than blocking the items of other groups that follow it in the queue.
+func (p *Processor[T]) waitForRoom(r T) bool {
+	for {
+		p.poolLock.Lock()
+		ok := p.hasRoom(r) || !p.saturated()
+		p.poolLock.Unlock()
+		if ok {
+			return true
+		}
+
+		select {
+		case <-p.hasRoomCh:
+			// An execution completed, check again
+		case <-p.resetCh:
+			// The first item in the queue has changed, restart the loop
+			return false
+		case <-p.stopCh:
+			return false
+		}
+	}
+}
+
+// Removes the item with the given key from the pending buffer, if it's there.
+// This must be invoked while the caller holds poolLock.
+func (p *Processor[T]) removePending(key string) {
+	p.pending = slices.DeleteFunc(p.pending, func(r T) bool {
+		return r.Key() == key
+	})
+}
+
+// Returns true if the item can be started right away, or if there's room for it in the pending buffer.
+// This must be invoked while the caller holds poolLock.
+func (p *Processor[T]) hasRoom(r T) bool {
+	return p.canStart(r) || len(p.pending) < p.opts.MaxPending
+}
+
+// Returns true if all workers are busy.
+// This must be invoked while the caller holds poolLock.
+func (p *Processor[T]) saturated() bool {
+	return p.opts.MaxConcurrent > 0 && p.running >= p.opts.MaxConcurrent
+}
+
+// Returns true if a worker is available for the item.
+// This must be invoked while the caller holds poolLock.
+func (p *Processor[T]) canStart(r T) bool {
+	if p.saturated() {
+		return false
+	}
+	if p.opts.MaxConcurrentPerGroup > 0 && p.opts.GroupFn != nil && p.perGroup[p.opts.GroupFn(r)] >= p.opts.MaxConcurrentPerGroup {
+		return false
+	}
+	return true
+}
+
+// Starts the pending items for which a worker is available, in order.
+// This must be invoked while the caller holds poolLock.
+func (p *Processor[T]) dispatch() {
+	// Do not start new executions after the processor has been stopped
+	if p.stopped.Load() {
 		return
 	}
 
-	go p.executeFn(r)
+	kept := p.pending[:0]
+	for _, r := range p.pending {
+		if !p.canStart(r) {
+			kept = append(kept, r)
+			continue
+		}
+
+		p.running++
+		if p.opts.GroupFn != nil {
+			p.perGroup[p.opts.GroupFn(r)]++
+		}
+		p.executing.Add(1)
+		go p.run(r)
+	}
+	clear(p.pending[len(kept):])
+	p.pending = kept
+}
+
+// Runs executeFn for an item and reports the outcome, then releases the worker.
+func (p *Processor[T]) run(r T) {
+	defer p.executing.Done()
+
+	start := p.clock.Now()
+	err := p.executeFn(p.ctx, r)
+	if p.onResult != nil {
+		p.onResult(ExecutionResult[T]{
+			Item:     r,
+			Err:      err,
+			Lag:      start.Sub(r.ScheduledTime()),
+			Duration: p.clock.Since(start),
+		})
+	}
+
+	// Release the worker and start the next pending items
+	p.poolLock.Lock()
+	p.running--
+	if p.opts.GroupFn != nil {
+		group := p.opts.GroupFn(r)
+		p.perGroup[group]--
+		if p.perGroup[group] <= 0 {
+			delete(p.perGroup, group)
+		}
+	}
+	p.dispatch()
+	p.poolLock.Unlock()
+
+	// Wake up the processing loop if it's waiting for room
+	select {
+	case p.hasRoomCh <- struct{}{}:
+	default:
+	}
 }
@@ -1,6 +1,8 @@
 package reminders
 
 import (
+	"context"
+	"errors"
 	"math/rand"
 	"runtime"
 	"strconv"
@@ -199,6 +201,39 @@ func TestProcessor(t *testing.T) {
 		}
 	})
 
+	t.Run("dequeue reminders matching a function", func(t *testing.T) {
+		// Enqueue 5 reminders
+		for i := 1; i <= 5; i++ {
+			err := processor.Enqueue(
+				newTestReminder(i, clock.Now().Add(time.Second*time.Duration(i))),
+			)
+			require.NoError(t, err)
+		}
+
+		// Advance tickers by a few ms to start
+		advanceTickers(0, 0)
+
+		// Dequeue odd reminders, including the one at the front of the queue
+		removed, err := processor.DequeueFunc(func(r *Reminder) bool {
+			n, _ := strconv.Atoi(r.Name)
+			return n%2 == 1
+		})
+		require.NoError(t, err)
+		assert.Len(t, removed, 3)
+		assert.Equal(t, 2, processor.Len())
+
+		// Advance tickers and assert only the remaining reminders are executed
+		for i := 1; i <= 5; i++ {
+			advanceTickers(time.Second, 1)
+			if i%2 == 1 {
+				assertNoExecutedReminder(t)
+				continue
+			}
+			received := assertExecutedReminder(t)
+			assert.Equal(t, strconv.Itoa(i), received.Name)
+		}
+	})
+
 	t.Run("replace reminder", func(t *testing.T) {
 		// Enqueue 5 reminders
 		for i := 1; i <= 5; i++ {
@@ -357,5 +392,263 @@ func TestProcessor(t *testing.T) {
 
 		// Stopping again is a nop (should not crash)
 		require.NoError(t, processor.Close())
+
+		// Items that were not processed can be drained
+		drained := processor.Drain()
+		require.Len(t, drained, 5)
+		for i := 1; i <= 5; i++ {
+			assert.Equal(t, strconv.Itoa(i), drained[i-1].Name)
+		}
+		assert.Empty(t, processor.Drain())
+	})
+}
+
+func TestProcessorWithContext(t *testing.T) {
+	clock := clocktesting.NewFakeClock(time.Now())
+
+	// Returns a processor that executes items with executeFn and sends their results on the returned channel
+	newProcessor := func(executeFn func(ctx context.Context, r *Reminder) error) (*Processor[*Reminder], <-chan ExecutionResult[*Reminder]) {
+		resultCh := make(chan ExecutionResult[*Reminder], 1)
+		processor := NewProcessorWithContext(executeFn, clock, ProcessorOptions[*Reminder]{
+			OnResult: func(res ExecutionResult[*Reminder]) {
+				resultCh <- res
+			},
+		})
+		return processor, resultCh
+	}
+
+	receiveResult := func(t *testing.T, resultCh <-chan ExecutionResult[*Reminder]) ExecutionResult[*Reminder] {
+		t.Helper()
+
+		select {
+		case res := <-resultCh:
+			return res
+		case <-time.After(time.Second):
+			t.Fatal("did not receive result in 1s")
+		}
+		return ExecutionResult[*Reminder]{}
+	}
+
+	t.Run("outcome is reported", func(t *testing.T) {
+		processor, resultCh := newProcessor(func(ctx context.Context, r *Reminder) error {
+			if r.Name == "2" {
+				return errors.New("simulated")
+			}
+			return nil
+		})
+		defer processor.Close()
+
+		require.NoError(t, processor.Enqueue(newTestReminder(1, clock.Now().Add(-time.Second))))
+		res := receiveResult(t, resultCh)
+		assert.Equal(t, "1", res.Item.Name)
+		assert.NoError(t, res.Err)
+		assert.Equal(t, time.Second, res.Lag)
+
+		require.NoError(t, processor.Enqueue(newTestReminder(2, clock.Now())))
+		res = receiveResult(t, resultCh)
+		assert.Equal(t, "2", res.Item.Name)
+		assert.EqualError(t, res.Err, "simulated")
+	})
+
+	t.Run("context is canceled when the processor is stopped", func(t *testing.T) {
+		startedCh := make(chan struct{})
+		processor, resultCh := newProcessor(func(ctx context.Context, r *Reminder) error {
+			close(startedCh)
+			<-ctx.Done()
+			return ctx.Err()
+		})
+
+		require.NoError(t, processor.Enqueue(newTestReminder(1, clock.Now())))
+		select {
+		case <-startedCh:
+		case <-time.After(time.Second):
+			t.Fatal("execution did not start in 1s")
+		}
+
+		// Close waits for the execution to return
+		require.NoError(t, processor.Close())
+		select {
+		case res := <-resultCh:
+			assert.ErrorIs(t, res.Err, context.Canceled)
+		default:
+			t.Fatal("execution did not return before the processor was stopped")
+		}
+	})
+}
+
+func TestProcessorWorkerPool(t *testing.T) {
+	clock := clocktesting.NewFakeClock(time.Now())
+
+	// Returns a processor whose executions block until release is closed or the processor is stopped, and the channel with the names of the executed items
+	newProcessor := func(t *testing.T, opts ProcessorOptions[*Reminder]) (processor *Processor[*Reminder], release chan struct{}, executedCh chan string) {
+		release = make(chan struct{})
+		executedCh = make(chan string, 10)
+		processor = NewProcessorWithContext(func(ctx context.Context, r *Reminder) error {
+			select {
+			case <-release:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			executedCh <- r.Name
+			return nil
+		}, clock, opts)
+		t.Cleanup(func() {
+			processor.Close()
+		})
+		return processor, release, executedCh
+	}
+
+	// Enqueues reminders that are due, in order
+	enqueue := func(t *testing.T, processor *Processor[*Reminder], rs ...*Reminder) {
+		t.Helper()
+		for _, r := range rs {
+			require.NoError(t, processor.Enqueue(r))
+		}
+	}
+	due := func(n int, actorType string) *Reminder {
+		r := newTestReminder(n, clock.Now().Add(time.Duration(n-10)*time.Second))
+		r.ActorType = actorType
+		return r
+	}
+
+	// Waits until the processor is executing and queueing the given number of items
+	assertState := func(t *testing.T, processor *Processor[*Reminder], executing int, queued int) {
+		t.Helper()
+		assert.Eventually(t, func() bool {
+			return processor.Executing() == executing && processor.Len() == queued
+		}, time.Second, 10*time.Millisecond, "expected %d executing and %d queued, got %d and %d", executing, queued, processor.Executing(), processor.Len())
+	}
+
+	collect := func(t *testing.T, executedCh <-chan string, n int) []string {
+		t.Helper()
+		res := make([]string, n)
+		for i := range res {
+			select {
+			case res[i] = <-executedCh:
+			case <-time.After(time.Second):
+				t.Fatalf("only %d items were executed in 1s", i)
+			}
+		}
+		return res
+	}
+
+	t.Run("global limit", func(t *testing.T) {
+		processor, release, executedCh := newProcessor(t, ProcessorOptions[*Reminder]{MaxConcurrent: 2, MaxPending: 10})
+		enqueue(t, processor, due(1, "a"), due(2, "a"), due(3, "b"), due(4, "b"), due(5, "c"))
+		assertState(t, processor, 2, 3)
+
+		close(release)
+		assert.ElementsMatch(t, []string{"1", "2", "3", "4", "5"}, collect(t, executedCh, 5))
+		assertState(t, processor, 0, 0)
+	})
+
+	t.Run("per-group limit", func(t *testing.T) {
+		processor, release, executedCh := newProcessor(t, ProcessorOptions[*Reminder]{
+			MaxConcurrentPerGroup: 1,
+			GroupFn:               func(r *Reminder) string { return r.ActorType },
+			MaxPending:            10,
+		})
+		enqueue(t, processor, due(1, "a"), due(2, "a"), due(3, "b"))
+
+		// Item 2 waits for item 1, but item 3 is in another group so it can start
+		assertState(t, processor, 2, 1)
+
+		close(release)
+		assert.ElementsMatch(t, []string{"1", "2", "3"}, collect(t, executedCh, 3))
+	})
+
+	t.Run("wait when saturated", func(t *testing.T) {
+		processor, release, executedCh := newProcessor(t, ProcessorOptions[*Reminder]{MaxConcurrent: 1, MaxPending: 1, Saturation: SaturationWait})
+		enqueue(t, processor, due(1, "a"), due(2, "a"), due(3, "a"))
+
+		// One item is executing, one is pending, and the last one waits in the queue
+		assertState(t, processor, 1, 2)
+
+		close(release)
+		assert.Equal(t, []string{"1", "2", "3"}, collect(t, executedCh, 3))
+	})
+
+	t.Run("saturated group does not block other groups", func(t *testing.T) {
+		rejectedCh := make(chan string, 10)
+		processor, release, executedCh := newProcessor(t, ProcessorOptions[*Reminder]{
+			MaxConcurrentPerGroup: 1,
+			GroupFn:               func(r *Reminder) string { return r.ActorType },
+			MaxPending:            1,
+			Saturation:            SaturationWait,
+			OnRejected: func(r *Reminder) {
+				rejectedCh <- r.Name
+			},
+		})
+		enqueue(t, processor, due(1, "a"), due(2, "a"), due(3, "a"), due(4, "b"))
+
+		// Item 2 waits for item 1 in the pending buffer, which is then full, so item 3 is rejected rather than blocking item 4
+		assert.Equal(t, []string{"3"}, collect(t, rejectedCh, 1))
+		assertState(t, processor, 2, 1)
+
+		close(release)
+		assert.ElementsMatch(t, []string{"1", "2", "4"}, collect(t, executedCh, 3))
+		assertState(t, processor, 0, 0)
+	})
+
+	t.Run("dequeue items waiting for a worker", func(t *testing.T) {
+		processor, release, executedCh := newProcessor(t, ProcessorOptions[*Reminder]{
+			MaxConcurrentPerGroup: 1,
+			GroupFn:               func(r *Reminder) string { return r.ActorType },
+			MaxPending:            10,
+		})
+		enqueue(t, processor, due(1, "a"), due(2, "a"), due(3, "a"), due(4, "a"))
+		assertState(t, processor, 1, 3)
+
+		// Items 2, 3, and 4 are in the pending buffer
+		require.NoError(t, processor.Dequeue(due(2, "a")))
+		removed, err := processor.DequeueFunc(func(r *Reminder) bool {
+			return r.Name == "3"
+		})
+		require.NoError(t, err)
+		require.Len(t, removed, 1)
+		assert.Equal(t, "3", removed[0].Name)
+
+		// Replacing item 4 with one that's not due removes it from the buffer
+		replaced := due(4, "a")
+		replaced.ExecutionTime = clock.Now().Add(time.Hour)
+		enqueue(t, processor, replaced)
+		assertState(t, processor, 1, 1)
+
+		close(release)
+		assert.Equal(t, []string{"1"}, collect(t, executedCh, 1))
+		assertState(t, processor, 0, 1)
+		assert.Empty(t, executedCh)
+	})
+
+	t.Run("reject when saturated", func(t *testing.T) {
+		rejectedCh := make(chan string, 10)
+		processor, release, executedCh := newProcessor(t, ProcessorOptions[*Reminder]{
+			MaxConcurrent: 1,
+			MaxPending:    1,
+			Saturation:    SaturationReject,
+			OnRejected: func(r *Reminder) {
+				rejectedCh <- r.Name
+			},
+		})
+		enqueue(t, processor, due(1, "a"), due(2, "a"), due(3, "a"), due(4, "a"))
+		assert.Equal(t, []string{"3", "4"}, collect(t, rejectedCh, 2))
+		assertState(t, processor, 1, 1)
+
+		close(release)
+		assert.Equal(t, []string{"1", "2"}, collect(t, executedCh, 2))
+	})
+
+	t.Run("pending items are drained when stopped", func(t *testing.T) {
+		processor, _, _ := newProcessor(t, ProcessorOptions[*Reminder]{MaxConcurrent: 1, MaxPending: 1})
+		enqueue(t, processor, due(1, "a"), due(2, "a"), due(3, "a"))
+		assertState(t, processor, 1, 2)
+
+		// Item 1 is canceled, and items 2 and 3 are not executed
+		require.NoError(t, processor.Close())
+		assert.Zero(t, processor.Executing())
+		drained := processor.Drain()
+		require.Len(t, drained, 2)
+		assert.Equal(t, "2", drained[0].Name)
+		assert.Equal(t, "3", drained[1].Name)
 	})
 }
@@ -98,6 +98,20 @@ func (p *Queue[T]) Remove(r T) {
 	delete(p.items, key)
 }
 
+// RemoveFunc removes all items for which fn returns true from the queue, and returns them.
+func (p *Queue[T]) RemoveFunc(fn func(r T) bool) []T {
+	var res []T
+	for _, item := range p.items {
+		if fn(item.value) {
+			res = append(res, item.value)
+		}
+	}
+	for _, r := range res {
+		p.Remove(r)
+	}
+	return res
+}
+
 // Update an item in the queue.
 func (p *Queue[T]) Update(r T) {
 	// If the item is not in the queue, this is a nop
@@ -1,6 +1,7 @@
 package reminders
 
 import (
+	"fmt"
 	"strconv"
 	"testing"
 	"time"
@@ -178,6 +179,27 @@ func TestRemoveFromQueue(t *testing.T) {
 	require.False(t, ok)
 }
 
+func TestRemoveFuncFromQueue(t *testing.T) {
+	queue := NewQueue[*Reminder]()
+
+	for i := 1; i <= 5; i++ {
+		queue.Insert(newTestReminder(i, fmt.Sprintf("202%d-01-01T01:01:01Z", i)), false)
+	}
+
+	// Remove the reminders with an even number
+	removed := queue.RemoveFunc(func(r *Reminder) bool {
+		n, _ := strconv.Atoi(r.Name)
+		return n%2 == 0
+	})
+	assert.Len(t, removed, 2)
+	require.Equal(t, 3, queue.Len())
+
+	// The remaining reminders are still in order
+	popAndCompare(t, queue, 1, "2021-01-01T01:01:01Z")
+	popAndCompare(t, queue, 3, "2023-01-01T01:01:01Z")
+	popAndCompare(t, queue, 5, "2025-01-01T01:01:01Z")
+}
+
 func TestUpdateInQueue(t *testing.T) {
 	queue := NewQueue[*Reminder]()
 
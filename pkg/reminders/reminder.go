@@ -2,7 +2,22 @@ package reminders
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
 	"time"
+	// Embed the time zone database, so TimeZone works on hosts without zoneinfo (e.g. minimal container images)
+	_ "time/tzdata"
+
+	"github.com/robfig/cron/v3"
+)
+
+var (
+	// ErrDataTooLarge is returned when the data of a reminder exceeds the maximum size.
+	ErrDataTooLarge = errors.New("reminder data is too large")
+	// ErrInvalidReminder is returned when a reminder's schedule is not valid.
+	ErrInvalidReminder = errors.New("reminder is not valid")
 )
 
 type Reminder struct {
@@ -11,20 +26,180 @@ type Reminder struct {
 	Name          string          `json:"name,omitempty"`
 	ExecutionTime time.Time       `json:"executionTime,omitempty"`
 	Period        time.Duration   `json:"period,omitempty"`
+	Cron          string          `json:"cron,omitempty"`
+	TimeZone      string          `json:"timeZone,omitempty"`
+	Repeats       int             `json:"repeats,omitempty"` // For reminders with a bounded repetition count, the number of executions left (including the next one); 0 means unbounded
 	TTL           time.Time       `json:"expirationTime,omitempty"`
 	Data          json.RawMessage `json:"data,omitempty"`
 
-	// Lease time is used internally to make sure the reminder hasn't been modified while it's being executed
-	LeaseTime int64 `json:"-"`
+	// Lease owner and lease time are used internally to make sure the reminder hasn't been modified while it's being executed
+	// Together, they form the lease token
+	LeaseOwner string `json:"-"`
+	LeaseTime  int64  `json:"-"`
+
+	// W3C traceparent of the span that created the reminder, which spans for its execution are linked to
+	TraceParent string `json:"-"`
+
+	// Retry state: number of failed attempts to execute the current iteration, when the next attempt is scheduled, and the history of the most recent failed attempts
+	Attempts       int             `json:"-"`
+	RetryTime      time.Time       `json:"-"`
+	FailedAttempts []FailedAttempt `json:"-"`
 }
 
+// Escapes the parts of a reminder's key, so they can be joined with "/" unambiguously.
+var keyPartEscaper = strings.NewReplacer("%", "%25", "/", "%2F")
+
 // Key returns the key for this unique reminder.
+// The key is in the format "actorType/actorID/name"; each part is escaped so that "/" and "%" are encoded as "%2F" and "%25" respectively, so different reminders never have the same key.
 func (r Reminder) Key() string {
-	return r.ActorType + "/" + r.ActorID + "/" + r.Name
+	return keyPartEscaper.Replace(r.ActorType) + "/" + keyPartEscaper.Replace(r.ActorID) + "/" + keyPartEscaper.Replace(r.Name)
 }
 
-// ScheduledTime returns the time the reminder is scheduled to be executed at.
+// LogValue implements slog.LogValuer, so reminders are logged as a group of fields.
+// The lease token is included only if the reminder is leased, and the retry state only if an execution has failed.
+func (r Reminder) LogValue() slog.Value {
+	attrs := []slog.Attr{
+		slog.String("actorType", r.ActorType),
+		slog.String("actorID", r.ActorID),
+		slog.String("name", r.Name),
+		slog.Time("executionTime", r.ExecutionTime),
+	}
+	if r.LeaseOwner != "" {
+		attrs = append(attrs,
+			slog.String("leaseOwner", r.LeaseOwner),
+			slog.Int64("leaseTime", r.LeaseTime),
+		)
+	}
+	if r.Attempts > 0 {
+		attrs = append(attrs,
+			slog.Int("attempts", r.Attempts),
+			slog.Time("retryTime", r.RetryTime),
+		)
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// ScheduledTime returns the time the reminder is scheduled to be executed at, which is the time of the next retry if a failed execution is being retried.
 // This is implemented to comply with the queueable interface.
 func (r Reminder) ScheduledTime() time.Time {
+	if !r.RetryTime.IsZero() {
+		return r.RetryTime
+	}
 	return r.ExecutionTime
 }
+
+// Expired returns true if the reminder has a TTL and it has expired at the given time.
+func (r Reminder) Expired(now time.Time) bool {
+	return !r.TTL.IsZero() && !now.Before(r.TTL)
+}
+
+// Validate returns an error if the reminder's schedule is not valid.
+func (r Reminder) Validate() error {
+	if r.Period < 0 {
+		return fmt.Errorf("%w: period must not be negative", ErrInvalidReminder)
+	}
+	if r.Repeats < 0 {
+		return fmt.Errorf("%w: repeats must not be negative", ErrInvalidReminder)
+	}
+	if r.Repeats > 0 && r.Period <= 0 && r.Cron == "" {
+		return fmt.Errorf("%w: repeats can only be set for repeating reminders", ErrInvalidReminder)
+	}
+
+	if r.Cron == "" {
+		if r.TimeZone != "" {
+			return fmt.Errorf("%w: timeZone can only be set for reminders with a cron schedule", ErrInvalidReminder)
+		}
+		return nil
+	}
+
+	if r.Period > 0 {
+		return fmt.Errorf("%w: period and cron cannot be both set", ErrInvalidReminder)
+	}
+	_, _, err := r.cronSchedule()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidReminder, err)
+	}
+	return nil
+}
+
+// NextCronTime returns the first time after the given one that matches the reminder's cron schedule.
+// Times are computed in the reminder's time zone (UTC if not set), so they follow DST changes: times that do not exist because clocks are moved forward are skipped, and times that occur twice because clocks are moved back are matched only once.
+func (r Reminder) NextCronTime(after time.Time) (time.Time, error) {
+	sched, loc, err := r.cronSchedule()
+	if err != nil {
+		return time.Time{}, err
+	}
+	next := sched.Next(after.In(loc))
+	if next.IsZero() {
+		return time.Time{}, errors.New("cron schedule has no future occurrences")
+	}
+	return next, nil
+}
+
+func (r Reminder) cronSchedule() (cron.Schedule, *time.Location, error) {
+	loc := time.UTC
+	if r.TimeZone != "" {
+		var err error
+		loc, err = time.LoadLocation(r.TimeZone)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid time zone '%s': %w", r.TimeZone, err)
+		}
+	}
+
+	sched, err := cron.ParseStandard(r.Cron)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid cron expression '%s': %w", r.Cron, err)
+	}
+	return sched, loc, nil
+}
+
+// NextIteration returns the reminder for the next iteration of a repeating reminder, scheduled after now.
+// If the reminder is late and some iterations were missed, those are skipped.
+// It returns nil if the reminder does not repeat or if it has no more iterations.
+func (r Reminder) NextIteration(now time.Time) *Reminder {
+	// If the reminder has a bounded repetition count, check if this is the last execution
+	if r.Repeats == 1 {
+		return nil
+	}
+
+	next := r
+	next.LeaseOwner = ""
+	next.LeaseTime = 0
+	next.Attempts = 0
+	next.RetryTime = time.Time{}
+	next.FailedAttempts = nil
+	if r.Repeats > 1 {
+		next.Repeats = r.Repeats - 1
+	}
+
+	switch {
+	case r.Cron != "":
+		after := r.ExecutionTime
+		if now.After(after) {
+			after = now
+		}
+		var err error
+		next.ExecutionTime, err = r.NextCronTime(after)
+		if err != nil {
+			// This should never happen as reminders are validated when they're added
+			return nil
+		}
+
+	case r.Period > 0:
+		next.ExecutionTime = r.ExecutionTime.Add(r.Period)
+		if next.ExecutionTime.Before(now) {
+			// Round up to the first iteration that is not before now
+			missed := (now.Sub(next.ExecutionTime) + r.Period - 1) / r.Period
+			next.ExecutionTime = next.ExecutionTime.Add(missed * r.Period)
+		}
+
+	default:
+		return nil
+	}
+
+	// If the next iteration is past the TTL, there are no more iterations
+	if next.Expired(next.ExecutionTime) {
+		return nil
+	}
+	return &next
+}
@@ -0,0 +1,256 @@
+package reminders
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReminderKey(t *testing.T) {
+	t.Run("plain key", func(t *testing.T) {
+		r := Reminder{ActorType: "type", ActorID: "id", Name: "name"}
+		assert.Equal(t, "type/id/name", r.Key())
+	})
+
+	t.Run("slashes and percent signs are escaped", func(t *testing.T) {
+		r := Reminder{ActorType: "type", ActorID: "a/b%", Name: "name"}
+		assert.Equal(t, "type/a%2Fb%25/name", r.Key())
+	})
+
+	t.Run("different reminders have different keys", func(t *testing.T) {
+		r1 := Reminder{ActorType: "type", ActorID: "a/b", Name: "c"}
+		r2 := Reminder{ActorType: "type", ActorID: "a", Name: "b/c"}
+		r3 := Reminder{ActorType: "type", ActorID: "a%2Fb", Name: "c"}
+		assert.NotEqual(t, r1.Key(), r2.Key())
+		assert.NotEqual(t, r1.Key(), r3.Key())
+	})
+}
+
+func TestReminderNextIteration(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("non-repeating reminder", func(t *testing.T) {
+		r := newTestReminder(1, start)
+		assert.Nil(t, r.NextIteration(start))
+	})
+
+	t.Run("repeating reminder", func(t *testing.T) {
+		r := newTestReminder(1, start)
+		r.Period = 10 * time.Second
+		r.LeaseTime = 42
+
+		next := r.NextIteration(start.Add(time.Second))
+		require.NotNil(t, next)
+		assert.Equal(t, start.Add(10*time.Second), next.ExecutionTime)
+		assert.Zero(t, next.LeaseTime)
+		assert.Equal(t, r.Key(), next.Key())
+
+		// Original reminder is not modified
+		assert.Equal(t, start, r.ExecutionTime)
+		assert.Equal(t, int64(42), r.LeaseTime)
+	})
+
+	t.Run("missed iterations are skipped", func(t *testing.T) {
+		r := newTestReminder(1, start)
+		r.Period = 10 * time.Second
+
+		next := r.NextIteration(start.Add(25 * time.Second))
+		require.NotNil(t, next)
+		assert.Equal(t, start.Add(30*time.Second), next.ExecutionTime)
+
+		// Iteration scheduled exactly at now is not skipped
+		next = r.NextIteration(start.Add(20 * time.Second))
+		require.NotNil(t, next)
+		assert.Equal(t, start.Add(20*time.Second), next.ExecutionTime)
+	})
+
+	t.Run("no iterations past the TTL", func(t *testing.T) {
+		r := newTestReminder(1, start)
+		r.Period = 10 * time.Second
+		r.TTL = start.Add(25 * time.Second)
+
+		next := r.NextIteration(start)
+		require.NotNil(t, next)
+		assert.Equal(t, start.Add(10*time.Second), next.ExecutionTime)
+		assert.Equal(t, r.TTL, next.TTL)
+
+		next = next.NextIteration(next.ExecutionTime)
+		require.NotNil(t, next)
+		assert.Equal(t, start.Add(20*time.Second), next.ExecutionTime)
+
+		assert.Nil(t, next.NextIteration(next.ExecutionTime))
+	})
+}
+
+func TestReminderExpired(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	r := newTestReminder(1, now)
+	assert.False(t, r.Expired(now), "reminders without TTL never expire")
+
+	r.TTL = now.Add(time.Second)
+	assert.False(t, r.Expired(now))
+	assert.True(t, r.Expired(now.Add(time.Second)))
+	assert.True(t, r.Expired(now.Add(time.Minute)))
+}
+
+func TestReminderLogValue(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Logs the reminder as JSON and returns the "reminder" group
+	logReminder := func(t *testing.T, r *Reminder) map[string]any {
+		t.Helper()
+
+		var buf bytes.Buffer
+		slog.New(slog.NewJSONHandler(&buf, nil)).Info("test", slog.Any("reminder", r))
+		var line struct {
+			Reminder map[string]any `json:"reminder"`
+		}
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+		return line.Reminder
+	}
+
+	t.Run("reminder without lease", func(t *testing.T) {
+		r := &Reminder{ActorType: "type", ActorID: "id", Name: "name", ExecutionTime: now}
+		assert.Equal(t, map[string]any{
+			"actorType":     "type",
+			"actorID":       "id",
+			"name":          "name",
+			"executionTime": "2023-01-01T00:00:00Z",
+		}, logReminder(t, r))
+	})
+
+	t.Run("lease token is included", func(t *testing.T) {
+		r := &Reminder{ActorType: "type", ActorID: "id", Name: "name", ExecutionTime: now, LeaseOwner: "owner", LeaseTime: 1000}
+		logged := logReminder(t, r)
+		assert.Equal(t, "owner", logged["leaseOwner"])
+		assert.Equal(t, 1000.0, logged["leaseTime"])
+	})
+
+	t.Run("retry state is included", func(t *testing.T) {
+		r := &Reminder{ActorType: "type", ActorID: "id", Name: "name", ExecutionTime: now, Attempts: 2, RetryTime: now.Add(time.Minute)}
+		logged := logReminder(t, r)
+		assert.Equal(t, 2.0, logged["attempts"])
+		assert.Equal(t, "2023-01-01T00:01:00Z", logged["retryTime"])
+	})
+}
+
+func TestReminderCron(t *testing.T) {
+	rome, err := time.LoadLocation("Europe/Rome")
+	require.NoError(t, err)
+
+	t.Run("weekdays in time zone", func(t *testing.T) {
+		// Friday, March 24, 2023, at 09:00 in Rome
+		r := newTestReminder(1, time.Date(2023, 3, 24, 9, 0, 0, 0, rome))
+		r.Cron = "0 9 * * MON-FRI"
+		r.TimeZone = "Europe/Rome"
+		require.NoError(t, r.Validate())
+
+		// Next iteration is on Monday, skipping the weekend
+		// Clocks moved forward on Sunday, so the offset from UTC changes
+		next := r.NextIteration(r.ExecutionTime)
+		require.NotNil(t, next)
+		assert.Equal(t, "2023-03-27T09:00:00+02:00", next.ExecutionTime.Format(time.RFC3339))
+		assert.Equal(t, time.Date(2023, 3, 27, 7, 0, 0, 0, time.UTC), next.ExecutionTime.UTC())
+	})
+
+	t.Run("defaults to UTC", func(t *testing.T) {
+		r := newTestReminder(1, time.Date(2023, 3, 24, 9, 0, 0, 0, time.UTC))
+		r.Cron = "@daily"
+
+		next := r.NextIteration(r.ExecutionTime)
+		require.NotNil(t, next)
+		assert.Equal(t, time.Date(2023, 3, 25, 0, 0, 0, 0, time.UTC), next.ExecutionTime.UTC())
+	})
+
+	t.Run("missed iterations are skipped", func(t *testing.T) {
+		r := newTestReminder(1, time.Date(2023, 3, 20, 9, 0, 0, 0, time.UTC))
+		r.Cron = "0 9 * * *"
+
+		next := r.NextIteration(time.Date(2023, 3, 24, 10, 0, 0, 0, time.UTC))
+		require.NotNil(t, next)
+		assert.Equal(t, time.Date(2023, 3, 25, 9, 0, 0, 0, time.UTC), next.ExecutionTime.UTC())
+	})
+
+	t.Run("DST transitions", func(t *testing.T) {
+		r := newTestReminder(1, time.Date(2023, 3, 25, 2, 30, 0, 0, rome))
+		r.Cron = "30 2 * * *"
+		r.TimeZone = "Europe/Rome"
+
+		// On March 26, 2023, 02:30 does not exist in Rome, so it's skipped
+		next := r.NextIteration(r.ExecutionTime)
+		require.NotNil(t, next)
+		assert.Equal(t, "2023-03-27T02:30:00+02:00", next.ExecutionTime.Format(time.RFC3339))
+
+		// On October 29, 2023, 02:30 occurs twice in Rome, but the reminder is executed once
+		r.ExecutionTime = time.Date(2023, 10, 29, 2, 30, 0, 0, rome)
+		next = r.NextIteration(r.ExecutionTime)
+		require.NotNil(t, next)
+		assert.Equal(t, "2023-10-30T02:30:00+01:00", next.ExecutionTime.Format(time.RFC3339))
+	})
+
+	t.Run("no iterations past the TTL", func(t *testing.T) {
+		r := newTestReminder(1, time.Date(2023, 3, 24, 9, 0, 0, 0, time.UTC))
+		r.Cron = "0 9 * * *"
+		r.TTL = time.Date(2023, 3, 25, 8, 0, 0, 0, time.UTC)
+
+		assert.Nil(t, r.NextIteration(r.ExecutionTime))
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		r := newTestReminder(1, time.Now())
+		r.Cron = "not a cron"
+		require.ErrorIs(t, r.Validate(), ErrInvalidReminder)
+
+		r.Cron = "0 9 * * *"
+		r.TimeZone = "Mars/Olympus_Mons"
+		require.ErrorIs(t, r.Validate(), ErrInvalidReminder)
+
+		r.TimeZone = ""
+		r.Period = time.Hour
+		require.ErrorIs(t, r.Validate(), ErrInvalidReminder)
+
+		r.Cron = ""
+		r.TimeZone = "Europe/Rome"
+		require.ErrorIs(t, r.Validate(), ErrInvalidReminder)
+	})
+}
+
+func TestReminderRepeats(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	r := newTestReminder(1, start)
+	r.Period = 10 * time.Second
+	r.Repeats = 3
+	require.NoError(t, r.Validate())
+
+	// Executions are counted down until the last one
+	next := r.NextIteration(start)
+	require.NotNil(t, next)
+	assert.Equal(t, 2, next.Repeats)
+	assert.Equal(t, 3, r.Repeats)
+
+	next = next.NextIteration(next.ExecutionTime)
+	require.NotNil(t, next)
+	assert.Equal(t, 1, next.Repeats)
+	assert.Equal(t, start.Add(20*time.Second), next.ExecutionTime)
+
+	assert.Nil(t, next.NextIteration(next.ExecutionTime))
+
+	// Repeats require a repeating reminder
+	r.Period = 0
+	require.ErrorIs(t, r.Validate(), ErrInvalidReminder)
+	r.Period = time.Second
+	r.Repeats = -1
+	require.ErrorIs(t, r.Validate(), ErrInvalidReminder)
+
+	// Negative periods are rejected
+	r.Period = -time.Second
+	r.Repeats = 0
+	require.ErrorIs(t, r.Validate(), ErrInvalidReminder)
+}
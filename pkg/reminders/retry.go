@@ -0,0 +1,71 @@
+package reminders
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// ErrRetriesExhausted is returned by ReminderStore.ExecuteReminder when the reminder failed to execute and it reached the maximum number of attempts, so it was moved to the dead-letter table.
+var ErrRetriesExhausted = errors.New("reminder failed to execute and reached the maximum number of attempts")
+
+// RetryPolicy determines when reminders that failed to execute are retried, using exponential backoff.
+type RetryPolicy struct {
+	// Delay before the first retry
+	InitialInterval time.Duration
+	// Maximum delay between retries
+	MaxInterval time.Duration
+	// Factor the delay is multiplied by after each failed attempt
+	Multiplier float64
+	// Randomization factor, between 0 and 1: each delay is randomly increased or decreased by up to this fraction
+	Jitter float64
+	// Maximum number of attempts to execute a reminder, including the first one; 0 means no limit
+	MaxAttempts int
+}
+
+// Delay returns how long to wait before retrying a reminder that failed to execute attempts times.
+func (p RetryPolicy) Delay(attempts int) time.Duration {
+	return p.delay(attempts, rand.Float64())
+}
+
+// Exhausted returns true if a reminder that failed to execute attempts times must not be retried anymore.
+func (p RetryPolicy) Exhausted(attempts int) bool {
+	return p.MaxAttempts > 0 && attempts >= p.MaxAttempts
+}
+
+// Computes the delay using rnd, in the range [0,1), as random number for the jitter.
+func (p RetryPolicy) delay(attempts int, rnd float64) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	d := float64(p.InitialInterval) * math.Pow(p.Multiplier, float64(attempts-1))
+	if d > float64(p.MaxInterval) {
+		d = float64(p.MaxInterval)
+	}
+	d *= 1 + p.Jitter*(2*rnd-1)
+	if d > float64(p.MaxInterval) {
+		d = float64(p.MaxInterval)
+	}
+	return time.Duration(d)
+}
+
+// RetryError is returned by ReminderStore.ExecuteReminder when the reminder failed to execute and it has been scheduled to be retried.
+type RetryError struct {
+	// Reminder with the retry state (Attempts and RetryTime)
+	// If LeaseOwner is not empty, the caller still owns the lease on the reminder, which it can use to retry the reminder without waiting for it to be acquired again
+	Reminder *Reminder
+	// Error returned by the execution
+	Err error
+}
+
+// Error implements the error interface.
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("failed to execute reminder (attempt %d), will retry at %s: %v", e.Reminder.Attempts, e.Reminder.RetryTime.Format(time.RFC3339Nano), e.Err)
+}
+
+// Unwrap returns the error returned by the execution.
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,58 @@
+package reminders
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	p := RetryPolicy{
+		InitialInterval: time.Second,
+		MaxInterval:     10 * time.Second,
+		Multiplier:      2,
+		Jitter:          0.5,
+		MaxAttempts:     5,
+	}
+
+	t.Run("delay grows exponentially up to the maximum", func(t *testing.T) {
+		tests := []struct {
+			attempts int
+			rnd      float64
+			want     time.Duration
+		}{
+			{attempts: 1, rnd: 0.5, want: time.Second},
+			{attempts: 2, rnd: 0.5, want: 2 * time.Second},
+			{attempts: 3, rnd: 0.5, want: 4 * time.Second},
+			{attempts: 4, rnd: 0.5, want: 8 * time.Second},
+			{attempts: 5, rnd: 0.5, want: 10 * time.Second},
+			{attempts: 100, rnd: 0.5, want: 10 * time.Second},
+			// Jitter randomly increases or decreases the delay, which never exceeds the maximum
+			{attempts: 1, rnd: 0, want: 500 * time.Millisecond},
+			{attempts: 1, rnd: 0.75, want: 1250 * time.Millisecond},
+			{attempts: 5, rnd: 0.75, want: 10 * time.Second},
+			{attempts: 5, rnd: 0, want: 5 * time.Second},
+		}
+		for _, tt := range tests {
+			assert.Equal(t, tt.want, p.delay(tt.attempts, tt.rnd), "attempts=%d rnd=%v", tt.attempts, tt.rnd)
+		}
+	})
+
+	t.Run("random delay is within the jitter", func(t *testing.T) {
+		for i := 0; i < 100; i++ {
+			d := p.Delay(2)
+			assert.GreaterOrEqual(t, d, time.Second)
+			assert.LessOrEqual(t, d, 3*time.Second)
+		}
+	})
+
+	t.Run("attempts are exhausted", func(t *testing.T) {
+		assert.False(t, p.Exhausted(4))
+		assert.True(t, p.Exhausted(5))
+
+		unlimited := p
+		unlimited.MaxAttempts = 0
+		assert.False(t, unlimited.Exhausted(1000))
+	})
+}
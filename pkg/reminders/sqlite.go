@@ -0,0 +1,453 @@
+package reminders
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Ensure SQLiteStore implements ReminderStore.
+var _ ReminderStore = (*SQLiteStore)(nil)
+
+// SQLiteStore is a ReminderStore that persists reminders in a SQLite database.
+type SQLiteStore struct {
+	db      *sql.DB
+	metrics *Metrics
+	logger  *slog.Logger
+}
+
+// NewSQLiteStore returns a new SQLiteStore object.
+// The database connection must have been opened with the "sqlite" driver, and Migrate must be invoked before using the store.
+// If metrics is not nil, the latency of the queries is recorded.
+// If logger is nil, the default logger is used.
+func NewSQLiteStore(db *sql.DB, metrics *Metrics, logger *slog.Logger) *SQLiteStore {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &SQLiteStore{
+		db:      db,
+		metrics: metrics,
+		logger:  logger,
+	}
+}
+
+// SaveReminder creates or replaces a reminder.
+func (s *SQLiteStore) SaveReminder(ctx context.Context, r *Reminder) error {
+	defer s.metrics.ObserveQuery("save", time.Now())
+
+	q := `INSERT OR REPLACE INTO reminders
+			(actor_type, actor_id, name, execution_time, period, cron, time_zone, repeats, ttl, data, lease_owner, lease_time, trace_parent, attempts, retry_time, failed_attempts)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	failedAttempts, err := encodeFailedAttempts(r.FailedAttempts)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, q,
+		r.ActorType,
+		r.ActorID,
+		r.Name,
+		r.ExecutionTime.UnixMilli(),
+		r.Period.Milliseconds(),
+		encodeString(r.Cron),
+		encodeString(r.TimeZone),
+		encodeInt(r.Repeats),
+		encodeTime(r.TTL),
+		r.Data,
+		encodeString(r.LeaseOwner),
+		r.LeaseTime,
+		encodeString(r.TraceParent),
+		encodeInt(r.Attempts),
+		encodeTime(r.RetryTime),
+		failedAttempts,
+	)
+	return err
+}
+
+// DeleteReminder removes a reminder.
+func (s *SQLiteStore) DeleteReminder(ctx context.Context, r *Reminder) (bool, error) {
+	defer s.metrics.ObserveQuery("delete", time.Now())
+
+	q := `DELETE FROM reminders WHERE actor_type = ? AND actor_id = ? AND name = ?`
+	res, err := s.db.ExecContext(ctx, q, r.ActorType, r.ActorID, r.Name)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// WatchReminders sends the reminders that are due soon on the returned channel.
+// SQLite does not support notifications, so this polls the database periodically.
+func (s *SQLiteStore) WatchReminders(ctx context.Context, req WatchRequest) (<-chan *Reminder, error) {
+	return WatchByPolling(ctx, s, req), nil
+}
+
+// AcquireReminders retrieves and leases the next batch of reminders.
+func (s *SQLiteStore) AcquireReminders(ctx context.Context, req AcquireRequest) (res []*Reminder, err error) {
+	defer s.metrics.ObserveQuery("acquire", time.Now())
+	defer func() {
+		if err == nil {
+			s.metrics.ObserveLeased(len(res))
+		}
+	}()
+
+	now := req.Now.UnixMilli()
+
+	// First, delete reminders whose TTL has expired, unless they have an active lease
+	q := `DELETE FROM reminders
+		WHERE
+			ttl IS NOT NULL
+			AND ttl <= ?
+			AND lease_time < ?`
+	_, err = s.db.ExecContext(ctx, q, now, now-req.LeaseDuration.Milliseconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete expired reminders: %w", err)
+	}
+
+	// Only acquire reminders for the actors selected by the filter, if any
+	actorsFilter, actorsArgs := actorsFilterClause(req.Actors)
+	if actorsFilter == "" {
+		return nil, nil
+	}
+
+	// Select the next reminders that are scheduled to be executed (or retried) within fetchAhead from now and that do not have an active lease
+	// The rows are atomically updated to acquire a lease
+	q = `UPDATE reminders
+		SET lease_owner = ?, lease_time = ?
+		WHERE ROWID IN (
+			SELECT ROWID
+			FROM reminders
+			WHERE
+				COALESCE(retry_time, execution_time) < ?
+				AND lease_time < ?
+				AND (ttl IS NULL OR ttl > ?)
+				AND ` + actorsFilter + `
+			ORDER BY COALESCE(retry_time, execution_time) ASC
+			LIMIT ?
+		)
+		RETURNING actor_type, actor_id, name, execution_time, period, cron, time_zone, repeats, ttl, data, lease_owner, lease_time, trace_parent, attempts, retry_time, failed_attempts`
+	args := []any{req.Owner, now, now + req.FetchAhead.Milliseconds(), now - req.LeaseDuration.Milliseconds(), now}
+	args = append(args, actorsArgs...)
+	args = append(args, req.BatchSize)
+	dbRes, err := s.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		// Ignore ErrNoRows
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer dbRes.Close()
+
+	// Scan each row in the result
+	res = make([]*Reminder, 0, req.BatchSize)
+	var (
+		executionTime  int64
+		period         int64
+		cron           sql.NullString
+		timeZone       sql.NullString
+		repeats        sql.NullInt64
+		ttl            sql.NullInt64
+		data           []byte
+		traceParent    sql.NullString
+		attempts       sql.NullInt64
+		retryTime      sql.NullInt64
+		failedAttempts sql.NullString
+	)
+	for dbRes.Next() {
+		// Scan the row
+		r := &Reminder{}
+		err = dbRes.Scan(&r.ActorType, &r.ActorID, &r.Name, &executionTime, &period, &cron, &timeZone, &repeats, &ttl, &data, &r.LeaseOwner, &r.LeaseTime, &traceParent, &attempts, &retryTime, &failedAttempts)
+		if err != nil {
+			return nil, err
+		}
+		r.ExecutionTime = time.UnixMilli(executionTime)
+		r.Period = time.Duration(period) * time.Millisecond
+		r.Cron = cron.String
+		r.TimeZone = timeZone.String
+		r.Repeats = int(repeats.Int64)
+		r.TTL = decodeTime(ttl)
+		// Scan into a []byte because database/sql can't store NULL in a json.RawMessage
+		r.Data = data
+		r.TraceParent = traceParent.String
+		r.Attempts = int(attempts.Int64)
+		r.RetryTime = decodeTime(retryTime)
+		r.FailedAttempts, err = decodeFailedAttempts(failedAttempts)
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, r)
+	}
+	return res, dbRes.Err()
+}
+
+// RenewLease renews the lease on a reminder.
+func (s *SQLiteStore) RenewLease(ctx context.Context, r *Reminder, now time.Time) (int64, error) {
+	defer s.metrics.ObserveQuery("renew_lease", time.Now())
+
+	leaseTime := now.UnixMilli()
+	q := `UPDATE reminders
+		SET lease_time = ?
+		WHERE actor_type = ?
+			AND actor_id = ?
+			AND name = ?
+			AND lease_owner = ?
+			AND lease_time = ?`
+	res, err := s.db.ExecContext(ctx, q, leaseTime, r.ActorType, r.ActorID, r.Name, r.LeaseOwner, r.LeaseTime)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute query: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count affected rows: %w", err)
+	}
+
+	// If no rows were affected, it means that the reminder was either deleted by another process, or we somehow lost the lease
+	if n == 0 {
+		return 0, ErrLeaseLost
+	}
+	return leaseTime, nil
+}
+
+// ReleaseLeases releases the leases on the reminders.
+func (s *SQLiteStore) ReleaseLeases(ctx context.Context, rs []*Reminder) error {
+	defer s.metrics.ObserveQuery("release_leases", time.Now())
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	// Automatically rollback
+	defer tx.Rollback()
+
+	q := `UPDATE reminders
+		SET lease_owner = NULL, lease_time = 0
+		WHERE actor_type = ?
+			AND actor_id = ?
+			AND name = ?
+			AND lease_owner = ?
+			AND lease_time = ?`
+	stmt, err := tx.PrepareContext(ctx, q)
+	if err != nil {
+		return fmt.Errorf("failed to prepare query: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, r := range rs {
+		_, err = stmt.ExecContext(ctx, r.ActorType, r.ActorID, r.Name, r.LeaseOwner, r.LeaseTime)
+		if err != nil {
+			return fmt.Errorf("failed to execute query: %w", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// CompleteReminder removes or reschedules a reminder that has been executed.
+func (s *SQLiteStore) CompleteReminder(ctx context.Context, r *Reminder, next *Reminder) error {
+	defer s.metrics.ObserveQuery("complete", time.Now())
+
+	// Delete or update the row in the database but only if it hasn't been modified yet
+	var (
+		res sql.Result
+		err error
+	)
+	if next == nil {
+		q := `DELETE FROM reminders
+			WHERE actor_type = ?
+				AND actor_id = ?
+				AND name = ?
+				AND lease_owner = ?
+				AND lease_time = ?`
+		res, err = s.db.ExecContext(ctx, q, r.ActorType, r.ActorID, r.Name, r.LeaseOwner, r.LeaseTime)
+	} else {
+		// If the reminder repeats, rather than deleting it, update its execution_time, reset the retry state, and release the lease
+		q := `UPDATE reminders
+			SET execution_time = ?, repeats = ?, attempts = NULL, retry_time = NULL, failed_attempts = NULL, lease_owner = NULL, lease_time = 0
+			WHERE actor_type = ?
+				AND actor_id = ?
+				AND name = ?
+				AND lease_owner = ?
+				AND lease_time = ?`
+		res, err = s.db.ExecContext(ctx, q, next.ExecutionTime.UnixMilli(), encodeInt(next.Repeats), r.ActorType, r.ActorID, r.Name, r.LeaseOwner, r.LeaseTime)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to execute query: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to count affected rows: %w", err)
+	}
+
+	// If no rows were affected, it means that the reminder was either deleted by another process, or we somehow lost the lease
+	if n == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// RetryReminder records a failed attempt to execute a reminder.
+func (s *SQLiteStore) RetryReminder(ctx context.Context, r *Reminder, retry *Reminder) error {
+	defer s.metrics.ObserveQuery("retry", time.Now())
+
+	failedAttempts, err := encodeFailedAttempts(retry.FailedAttempts)
+	if err != nil {
+		return err
+	}
+
+	q := `UPDATE reminders
+		SET attempts = ?, retry_time = ?, failed_attempts = ?, lease_owner = ?, lease_time = ?
+		WHERE actor_type = ?
+			AND actor_id = ?
+			AND name = ?
+			AND lease_owner = ?
+			AND lease_time = ?`
+	res, err := s.db.ExecContext(ctx, q,
+		encodeInt(retry.Attempts), encodeTime(retry.RetryTime), failedAttempts, encodeString(retry.LeaseOwner), retry.LeaseTime,
+		r.ActorType, r.ActorID, r.Name, r.LeaseOwner, r.LeaseTime,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to execute query: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to count affected rows: %w", err)
+	}
+
+	// If no rows were affected, it means that the reminder was either deleted by another process, or we somehow lost the lease
+	if n == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// ExecuteReminder executes a reminder and then completes it.
+// Transactions in SQLite block the entire database, so rather than keeping a transaction open while the reminder is executed, this renews the lease periodically.
+func (s *SQLiteStore) ExecuteReminder(ctx context.Context, r *Reminder, req ExecuteRequest, executeFn ExecuteFn) error {
+	return ExecuteWithLeaseRenewal(ctx, s, r, req, executeFn)
+}
+
+// ListLeases returns all reminders that have been leased.
+func (s *SQLiteStore) ListLeases(ctx context.Context) ([]*Reminder, error) {
+	defer s.metrics.ObserveQuery("list_leases", time.Now())
+
+	q := `SELECT actor_type, actor_id, name, execution_time, lease_owner, lease_time
+		FROM reminders
+		WHERE lease_owner IS NOT NULL
+		ORDER BY execution_time ASC`
+	dbRes, err := s.db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer dbRes.Close()
+
+	res := []*Reminder{}
+	var executionTime int64
+	for dbRes.Next() {
+		r := &Reminder{}
+		err = dbRes.Scan(&r.ActorType, &r.ActorID, &r.Name, &executionTime, &r.LeaseOwner, &r.LeaseTime)
+		if err != nil {
+			return nil, err
+		}
+		r.ExecutionTime = time.UnixMilli(executionTime)
+
+		res = append(res, r)
+	}
+	return res, dbRes.Err()
+}
+
+// actorsFilterClause returns the condition for the WHERE clause that selects reminders for the actors in the filters, and its arguments.
+// If filters is nil, the condition matches all rows. If filters is empty, it returns an empty string, as no row can match.
+func actorsFilterClause(filters []ActorFilter) (string, []any) {
+	if filters == nil {
+		return "1", nil
+	}
+	if len(filters) == 0 {
+		return "", nil
+	}
+
+	conds := make([]string, len(filters))
+	args := []any{}
+	for i, f := range filters {
+		args = append(args, f.ActorType)
+		if len(f.ActorIDs) == 0 {
+			conds[i] = "actor_type = ?"
+			continue
+		}
+		conds[i] = "(actor_type = ? AND actor_id IN (?" + strings.Repeat(", ?", len(f.ActorIDs)-1) + "))"
+		for _, id := range f.ActorIDs {
+			args = append(args, id)
+		}
+	}
+	return "(" + strings.Join(conds, " OR ") + ")", args
+}
+
+// encodeTime returns the value stored in the database for an optional time: the zero time is stored as NULL, and other values as Unix epoch in milliseconds.
+func encodeTime(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UnixMilli()
+}
+
+// decodeTime is the inverse of encodeTime.
+func decodeTime(v sql.NullInt64) time.Time {
+	if !v.Valid {
+		return time.Time{}
+	}
+	return time.UnixMilli(v.Int64)
+}
+
+// encodeString returns the value stored in the database for an optional string: empty strings are stored as NULL.
+func encodeString(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// encodeFailedAttempts returns the value stored in the database for the history of failed attempts: an empty history is stored as NULL, and other values as JSON.
+func encodeFailedAttempts(attempts []FailedAttempt) (any, error) {
+	if len(attempts) == 0 {
+		return nil, nil
+	}
+	enc, err := json.Marshal(attempts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode failed attempts: %w", err)
+	}
+	return string(enc), nil
+}
+
+// decodeFailedAttempts is the inverse of encodeFailedAttempts.
+func decodeFailedAttempts(v sql.NullString) ([]FailedAttempt, error) {
+	if !v.Valid || v.String == "" {
+		return nil, nil
+	}
+	var res []FailedAttempt
+	err := json.Unmarshal([]byte(v.String), &res)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode failed attempts: %w", err)
+	}
+	return res, nil
+}
+
+// encodeInt returns the value stored in the database for an optional integer: zero is stored as NULL.
+func encodeInt(n int) any {
+	if n == 0 {
+		return nil
+	}
+	return n
+}
@@ -0,0 +1,224 @@
+package reminders
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DeadLetterReminder moves a reminder that exhausted its attempts to the dead-letter table.
+func (s *SQLiteStore) DeadLetterReminder(ctx context.Context, r *Reminder, dl *DeadLetter) error {
+	defer s.metrics.ObserveQuery("dead_letter", time.Now())
+
+	failedAttempts, err := encodeFailedAttempts(dl.FailedAttempts)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	// Automatically rollback
+	defer tx.Rollback()
+
+	// Delete the reminder, but only if we still own the lease
+	q := `DELETE FROM reminders
+		WHERE actor_type = ?
+			AND actor_id = ?
+			AND name = ?
+			AND lease_owner = ?
+			AND lease_time = ?`
+	res, err := tx.ExecContext(ctx, q, r.ActorType, r.ActorID, r.Name, r.LeaseOwner, r.LeaseTime)
+	if err != nil {
+		return fmt.Errorf("failed to delete reminder: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to count affected rows: %w", err)
+	}
+	if n == 0 {
+		return ErrLeaseLost
+	}
+
+	q = `INSERT INTO reminders_dead_letter
+			(actor_type, actor_id, name, execution_time, period, cron, time_zone, repeats, ttl, data, trace_parent, attempts, last_error, failed_attempts, dead_letter_time)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	original := dl.Reminder
+	res, err = tx.ExecContext(ctx, q,
+		original.ActorType,
+		original.ActorID,
+		original.Name,
+		original.ExecutionTime.UnixMilli(),
+		original.Period.Milliseconds(),
+		encodeString(original.Cron),
+		encodeString(original.TimeZone),
+		encodeInt(original.Repeats),
+		encodeTime(original.TTL),
+		original.Data,
+		encodeString(original.TraceParent),
+		dl.Attempts,
+		dl.LastError,
+		failedAttempts,
+		dl.Time.UnixMilli(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert reminder in the dead-letter table: %w", err)
+	}
+	dl.ID, err = res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to retrieve ID of the dead-lettered reminder: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// ListDeadLetters returns all reminders in the dead-letter table.
+func (s *SQLiteStore) ListDeadLetters(ctx context.Context) ([]*DeadLetter, error) {
+	defer s.metrics.ObserveQuery("list_dead_letters", time.Now())
+
+	q := `SELECT id, actor_type, actor_id, name, execution_time, period, cron, time_zone, repeats, ttl, data, trace_parent, attempts, last_error, failed_attempts, dead_letter_time
+		FROM reminders_dead_letter
+		ORDER BY id ASC`
+	dbRes, err := s.db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer dbRes.Close()
+
+	res := []*DeadLetter{}
+	for dbRes.Next() {
+		dl, err := scanDeadLetter(dbRes)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, dl)
+	}
+	return res, dbRes.Err()
+}
+
+// RequeueDeadLetter moves a reminder from the dead-letter table back to the reminders table.
+func (s *SQLiteStore) RequeueDeadLetter(ctx context.Context, id int64) (*Reminder, error) {
+	defer s.metrics.ObserveQuery("requeue_dead_letter", time.Now())
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	// Automatically rollback
+	defer tx.Rollback()
+
+	q := `DELETE FROM reminders_dead_letter
+		WHERE id = ?
+		RETURNING id, actor_type, actor_id, name, execution_time, period, cron, time_zone, repeats, ttl, data, trace_parent, attempts, last_error, failed_attempts, dead_letter_time`
+	dl, err := scanDeadLetter(tx.QueryRowContext(ctx, q, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrDeadLetterNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to delete reminder from the dead-letter table: %w", err)
+	}
+
+	// Do not replace a reminder that was created with the same key after this one was dead-lettered
+	r := dl.Reminder
+	q = `INSERT OR IGNORE INTO reminders
+			(actor_type, actor_id, name, execution_time, period, cron, time_zone, repeats, ttl, data, lease_time, trace_parent)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 0, ?)`
+	res, err := tx.ExecContext(ctx, q,
+		r.ActorType,
+		r.ActorID,
+		r.Name,
+		r.ExecutionTime.UnixMilli(),
+		r.Period.Milliseconds(),
+		encodeString(r.Cron),
+		encodeString(r.TimeZone),
+		encodeInt(r.Repeats),
+		encodeTime(r.TTL),
+		r.Data,
+		encodeString(r.TraceParent),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert reminder: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("failed to count affected rows: %w", err)
+	}
+	if n == 0 {
+		return nil, ErrReminderExists
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return r, nil
+}
+
+// PurgeDeadLetters removes reminders from the dead-letter table.
+func (s *SQLiteStore) PurgeDeadLetters(ctx context.Context, ids []int64) (int, error) {
+	defer s.metrics.ObserveQuery("purge_dead_letters", time.Now())
+
+	q := `DELETE FROM reminders_dead_letter`
+	args := make([]any, len(ids))
+	if ids != nil {
+		if len(ids) == 0 {
+			return 0, nil
+		}
+		q += ` WHERE id IN (?` + strings.Repeat(", ?", len(ids)-1) + `)`
+		for i, id := range ids {
+			args[i] = id
+		}
+	}
+	res, err := s.db.ExecContext(ctx, q, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute query: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count affected rows: %w", err)
+	}
+	return int(n), nil
+}
+
+// Scans a row of the dead-letter table, from either sql.Row or sql.Rows.
+func scanDeadLetter(row interface{ Scan(dest ...any) error }) (*DeadLetter, error) {
+	var (
+		dl             = &DeadLetter{Reminder: &Reminder{}}
+		r              = dl.Reminder
+		executionTime  int64
+		period         int64
+		cron           sql.NullString
+		timeZone       sql.NullString
+		repeats        sql.NullInt64
+		ttl            sql.NullInt64
+		data           []byte
+		traceParent    sql.NullString
+		failedAttempts sql.NullString
+		deadLetterTime int64
+	)
+	err := row.Scan(&dl.ID, &r.ActorType, &r.ActorID, &r.Name, &executionTime, &period, &cron, &timeZone, &repeats, &ttl, &data, &traceParent, &dl.Attempts, &dl.LastError, &failedAttempts, &deadLetterTime)
+	if err != nil {
+		return nil, err
+	}
+	r.ExecutionTime = time.UnixMilli(executionTime)
+	r.Period = time.Duration(period) * time.Millisecond
+	r.Cron = cron.String
+	r.TimeZone = timeZone.String
+	r.Repeats = int(repeats.Int64)
+	r.TTL = decodeTime(ttl)
+	r.Data = data
+	r.TraceParent = traceParent.String
+	dl.FailedAttempts, err = decodeFailedAttempts(failedAttempts)
+	if err != nil {
+		return nil, err
+	}
+	dl.Time = time.UnixMilli(deadLetterTime)
+	return dl, nil
+}
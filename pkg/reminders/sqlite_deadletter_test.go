@@ -0,0 +1,133 @@
+package reminders
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLiteDeadLetters(t *testing.T) {
+	store := NewSQLiteStore(newTestDB(t), nil, nil)
+	ctx := context.Background()
+	now := time.Now()
+
+	// Saves a reminder and acquires it, then moves it to the dead-letter table after 3 failed attempts
+	deadLetter := func(t *testing.T, name string) *DeadLetter {
+		t.Helper()
+
+		r := &Reminder{ActorType: "type", ActorID: "id", Name: name, ExecutionTime: now, Period: time.Minute, Repeats: 2, TTL: now.Add(time.Hour), Data: json.RawMessage(`{"x":1}`), TraceParent: testTraceParent}
+		require.NoError(t, store.SaveReminder(ctx, r))
+		acquired, err := store.AcquireReminders(ctx, AcquireRequest{
+			Now:           now,
+			Owner:         "owner1",
+			FetchAhead:    5 * time.Second,
+			LeaseDuration: 30 * time.Second,
+			BatchSize:     1,
+			Actors:        []ActorFilter{{ActorType: "type", ActorIDs: []string{"id"}}},
+		})
+		require.NoError(t, err)
+		require.Len(t, acquired, 1)
+
+		leased := acquired[0]
+		leased.Attempts = 2
+		leased.FailedAttempts = []FailedAttempt{{Attempt: 1, Time: now, Error: "first"}, {Attempt: 2, Time: now, Error: "second"}}
+		dl := NewDeadLetter(leased, 3, now, errors.New("third"))
+
+		// The lease must still be owned by the caller
+		stale := *leased
+		stale.LeaseTime--
+		require.ErrorIs(t, store.DeadLetterReminder(ctx, &stale, dl), ErrLeaseLost)
+
+		require.NoError(t, store.DeadLetterReminder(ctx, leased, dl))
+		assert.NotZero(t, dl.ID)
+		return dl
+	}
+
+	t.Run("reminders are moved to the dead-letter table", func(t *testing.T) {
+		dl := deadLetter(t, "r1")
+
+		leases, err := store.ListLeases(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, leases)
+
+		listed, err := store.ListDeadLetters(ctx)
+		require.NoError(t, err)
+		require.Len(t, listed, 1)
+		assert.Equal(t, dl.ID, listed[0].ID)
+		assert.Equal(t, 3, listed[0].Attempts)
+		assert.Equal(t, "third", listed[0].LastError)
+		assert.Equal(t, now.UnixMilli(), listed[0].Time.UnixMilli())
+		require.Len(t, listed[0].FailedAttempts, 3)
+		assert.Equal(t, []string{"first", "second", "third"}, []string{listed[0].FailedAttempts[0].Error, listed[0].FailedAttempts[1].Error, listed[0].FailedAttempts[2].Error})
+
+		r := listed[0].Reminder
+		assert.Equal(t, "type/id/r1", r.Key())
+		assert.Equal(t, now.UnixMilli(), r.ExecutionTime.UnixMilli())
+		assert.Equal(t, time.Minute, r.Period)
+		assert.Equal(t, 2, r.Repeats)
+		assert.Equal(t, now.Add(time.Hour).UnixMilli(), r.TTL.UnixMilli())
+		assert.JSONEq(t, `{"x":1}`, string(r.Data))
+		assert.Equal(t, testTraceParent, r.TraceParent)
+	})
+
+	t.Run("requeue dead-lettered reminders", func(t *testing.T) {
+		listed, err := store.ListDeadLetters(ctx)
+		require.NoError(t, err)
+		require.Len(t, listed, 1)
+
+		requeued, err := store.RequeueDeadLetter(ctx, listed[0].ID)
+		require.NoError(t, err)
+		assert.Equal(t, "type/id/r1", requeued.Key())
+		_, err = store.RequeueDeadLetter(ctx, listed[0].ID)
+		require.ErrorIs(t, err, ErrDeadLetterNotFound)
+
+		// The reminder can be acquired again, without retry state
+		acquired, err := store.AcquireReminders(ctx, AcquireRequest{Now: now, Owner: "owner2", FetchAhead: time.Second, LeaseDuration: 30 * time.Second, BatchSize: 10})
+		require.NoError(t, err)
+		require.Len(t, acquired, 1)
+		assert.Equal(t, "type/id/r1", acquired[0].Key())
+		assert.Equal(t, 2, acquired[0].Repeats)
+		assert.Zero(t, acquired[0].Attempts)
+		assert.Empty(t, acquired[0].FailedAttempts)
+	})
+
+	t.Run("requeue does not replace existing reminders", func(t *testing.T) {
+		dl := deadLetter(t, "r2")
+		require.NoError(t, store.SaveReminder(ctx, &Reminder{ActorType: "type", ActorID: "id", Name: "r2", ExecutionTime: now.Add(time.Hour)}))
+
+		_, err := store.RequeueDeadLetter(ctx, dl.ID)
+		require.ErrorIs(t, err, ErrReminderExists)
+
+		// The reminder is still in the dead-letter table
+		listed, err := store.ListDeadLetters(ctx)
+		require.NoError(t, err)
+		require.Len(t, listed, 1)
+		assert.Equal(t, dl.ID, listed[0].ID)
+	})
+
+	t.Run("purge dead-lettered reminders", func(t *testing.T) {
+		_, err := store.DeleteReminder(ctx, &Reminder{ActorType: "type", ActorID: "id", Name: "r2"})
+		require.NoError(t, err)
+		dl3 := deadLetter(t, "r3")
+
+		n, err := store.PurgeDeadLetters(ctx, []int64{dl3.ID, dl3.ID + 100})
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+		n, err = store.PurgeDeadLetters(ctx, []int64{})
+		require.NoError(t, err)
+		assert.Zero(t, n)
+
+		deadLetter(t, "r4")
+		n, err = store.PurgeDeadLetters(ctx, nil)
+		require.NoError(t, err)
+		assert.Equal(t, 2, n)
+		listed, err := store.ListDeadLetters(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, listed)
+	})
+}
@@ -0,0 +1,348 @@
+package reminders
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// Name of the table that stores metadata, including the schema version
+const sqliteMetadataTable = "reminders_metadata"
+
+// Key in the metadata table for the schema version, which is the number of migrations that have been applied
+const sqliteSchemaVersionKey = "schema-version"
+
+// sqliteMigration is a step that upgrades the schema of the database.
+type sqliteMigration struct {
+	// Description of the migration, used in logs and errors
+	name string
+	// Function that performs the migration, within a transaction
+	fn func(ctx context.Context, tx *sql.Tx, logger *slog.Logger) error
+}
+
+// Migrations for the SQLite database, in order.
+// Migrations must never be removed or re-ordered; to change the schema, append a new migration.
+// Databases created before migrations were versioned do not have a schema version, so the first migrations check the state of the schema before changing it.
+var sqliteMigrations = []sqliteMigration{
+	{
+		name: "create reminders table",
+		fn: func(ctx context.Context, tx *sql.Tx, logger *slog.Logger) error {
+			_, err := tx.ExecContext(ctx,
+				`CREATE TABLE IF NOT EXISTS reminders (
+					target TEXT NOT NULL PRIMARY KEY,
+					execution_time INTEGER NOT NULL,
+					period INTEGER,
+					ttl INTEGER,
+					data BLOB,
+					lease_time INTEGER NOT NULL
+				);
+
+				CREATE INDEX IF NOT EXISTS execution_time_idx ON reminders (execution_time ASC);
+				CREATE INDEX IF NOT EXISTS lease_time_idx ON reminders (lease_time ASC);`,
+			)
+			return err
+		},
+	},
+	{
+		name: "add cron, time_zone, repeats, and lease_owner columns",
+		fn: func(ctx context.Context, tx *sql.Tx, logger *slog.Logger) error {
+			err := addMissingColumns(ctx, tx, []string{"cron TEXT", "time_zone TEXT", "repeats INTEGER", "lease_owner TEXT"})
+			if err != nil {
+				return err
+			}
+
+			// Older versions stored a negative number for reminders without a TTL
+			_, err = tx.ExecContext(ctx, `UPDATE reminders SET ttl = NULL WHERE ttl < 0`)
+			return err
+		},
+	},
+	{
+		name: "use actor type, actor ID, and name as primary key",
+		fn:   migrateTargetColumn,
+	},
+	{
+		name: "add trace_parent column",
+		fn: func(ctx context.Context, tx *sql.Tx, logger *slog.Logger) error {
+			return addMissingColumns(ctx, tx, []string{"trace_parent TEXT"})
+		},
+	},
+	{
+		name: "add attempts and retry_time columns",
+		fn: func(ctx context.Context, tx *sql.Tx, logger *slog.Logger) error {
+			err := addMissingColumns(ctx, tx, []string{"attempts INTEGER", "retry_time INTEGER"})
+			if err != nil {
+				return err
+			}
+
+			// Reminders are acquired by the time they're due, which is the time of the next retry if set
+			_, err = tx.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS due_time_idx ON reminders (COALESCE(retry_time, execution_time) ASC)`)
+			return err
+		},
+	},
+	{
+		name: "add failed_attempts column and dead-letter table",
+		fn: func(ctx context.Context, tx *sql.Tx, logger *slog.Logger) error {
+			err := addMissingColumns(ctx, tx, []string{"failed_attempts TEXT"})
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.ExecContext(ctx,
+				`CREATE TABLE IF NOT EXISTS reminders_dead_letter (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					actor_type TEXT NOT NULL,
+					actor_id TEXT NOT NULL,
+					name TEXT NOT NULL,
+					execution_time INTEGER NOT NULL,
+					period INTEGER,
+					cron TEXT,
+					time_zone TEXT,
+					repeats INTEGER,
+					ttl INTEGER,
+					data BLOB,
+					trace_parent TEXT,
+					attempts INTEGER NOT NULL,
+					last_error TEXT NOT NULL,
+					failed_attempts TEXT,
+					dead_letter_time INTEGER NOT NULL
+				)`,
+			)
+			return err
+		},
+	},
+}
+
+// Migrate creates the reminders table, or upgrades it to the current schema.
+// It's safe to invoke this from multiple processes at the same time, as migrations are performed in a transaction that holds the write lock on the database.
+func (s *SQLiteStore) Migrate(ctx context.Context) error {
+	return runSQLiteMigrations(ctx, s.db, sqliteMigrations, s.logger)
+}
+
+// Applies the migrations that haven't been applied yet.
+func runSQLiteMigrations(ctx context.Context, db *sql.DB, migrations []sqliteMigration, logger *slog.Logger) error {
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+sqliteMetadataTable+` (
+		key TEXT NOT NULL PRIMARY KEY,
+		value TEXT NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create metadata table: %w", err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	// Automatically rollback
+	defer tx.Rollback()
+
+	// Start with a write, so the transaction acquires the write lock right away
+	// Other processes that are running migrations at the same time wait until this transaction is done, and then they see the updated version
+	_, err = tx.ExecContext(ctx, `INSERT OR IGNORE INTO `+sqliteMetadataTable+` (key, value) VALUES (?, '0')`, sqliteSchemaVersionKey)
+	if err != nil {
+		return fmt.Errorf("failed to initialize schema version: %w", err)
+	}
+
+	var versionStr string
+	err = tx.QueryRowContext(ctx, `SELECT value FROM `+sqliteMetadataTable+` WHERE key = ?`, sqliteSchemaVersionKey).Scan(&versionStr)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve schema version: %w", err)
+	}
+	version, err := strconv.Atoi(versionStr)
+	if err != nil || version < 0 {
+		return fmt.Errorf("invalid schema version '%s'", versionStr)
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("schema version %d is newer than the latest supported version %d", version, len(migrations))
+	}
+	if version == len(migrations) {
+		return nil
+	}
+
+	for i := version; i < len(migrations); i++ {
+		logger.Info("Applying migration", slog.Int("version", i+1), slog.String("migration", migrations[i].name))
+		err = migrations[i].fn(ctx, tx, logger)
+		if err != nil {
+			return fmt.Errorf("failed to apply migration %d (%s): %w", i+1, migrations[i].name, err)
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE `+sqliteMetadataTable+` SET value = ? WHERE key = ?`, strconv.Itoa(len(migrations)), sqliteSchemaVersionKey)
+	if err != nil {
+		return fmt.Errorf("failed to update schema version: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// Migrates a reminders table that uses the "target" column as primary key to a table with separate actor_type, actor_id, and name columns.
+// SQLite cannot change the primary key of a table, so the table is re-created.
+func migrateTargetColumn(ctx context.Context, tx *sql.Tx, logger *slog.Logger) error {
+	columns, err := tableColumns(ctx, tx)
+	if err != nil {
+		return err
+	}
+	if !columns["target"] {
+		// Table was created with the current schema before migrations were versioned
+		return nil
+	}
+
+	// Some versions added the actor columns without changing the primary key
+	err = addMissingColumns(ctx, tx, []string{"actor_type TEXT", "actor_id TEXT", "name TEXT"})
+	if err != nil {
+		return err
+	}
+	err = backfillActorColumns(ctx, tx, logger)
+	if err != nil {
+		return fmt.Errorf("failed to populate actor columns: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`DROP INDEX IF EXISTS actor_idx;
+		DROP INDEX IF EXISTS execution_time_idx;
+		DROP INDEX IF EXISTS lease_time_idx;
+
+		CREATE TABLE reminders_new (
+			actor_type TEXT NOT NULL,
+			actor_id TEXT NOT NULL,
+			name TEXT NOT NULL,
+			execution_time INTEGER NOT NULL,
+			period INTEGER,
+			cron TEXT,
+			time_zone TEXT,
+			repeats INTEGER,
+			ttl INTEGER,
+			data BLOB,
+			lease_owner TEXT,
+			lease_time INTEGER NOT NULL,
+			PRIMARY KEY (actor_type, actor_id, name)
+		);
+
+		INSERT INTO reminders_new
+			(actor_type, actor_id, name, execution_time, period, cron, time_zone, repeats, ttl, data, lease_owner, lease_time)
+		SELECT actor_type, actor_id, name, execution_time, period, cron, time_zone, repeats, ttl, data, lease_owner, lease_time
+		FROM reminders;
+
+		DROP TABLE reminders;
+		ALTER TABLE reminders_new RENAME TO reminders;
+
+		CREATE INDEX execution_time_idx ON reminders (execution_time ASC);
+		CREATE INDEX lease_time_idx ON reminders (lease_time ASC);`,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to re-create table: %w", err)
+	}
+	return nil
+}
+
+// Populates the actor_type, actor_id, and name columns from the target column, for rows that don't have them.
+// Targets with more than two "/" are ambiguous, as the parts were not escaped: these are logged as warnings, so users can check the reminders were split as expected.
+func backfillActorColumns(ctx context.Context, tx *sql.Tx, logger *slog.Logger) error {
+	rows, err := tx.QueryContext(ctx, "SELECT target FROM reminders WHERE actor_type IS NULL")
+	if err != nil {
+		return err
+	}
+	var targets []string
+	var target string
+	for rows.Next() {
+		err = rows.Scan(&target)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		targets = append(targets, target)
+	}
+	rows.Close()
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+
+	for _, target := range targets {
+		actorType, actorID, name, err := parseLegacyTarget(target)
+		if err != nil {
+			return err
+		}
+		if strings.Count(target, "/") > 2 {
+			logger.Warn("Legacy reminder target contains more than two '/': the actor ID is assumed to contain the extra ones",
+				slog.String("target", target),
+				slog.String("actorType", actorType),
+				slog.String("actorID", actorID),
+				slog.String("name", name),
+			)
+		}
+		_, err = tx.ExecContext(ctx,
+			"UPDATE reminders SET actor_type = ?, actor_id = ?, name = ? WHERE target = ?",
+			actorType, actorID, name, target,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Parses a target key stored by older versions, in the format "actorType/actorID/name", which are not escaped.
+// Because actor IDs are the most likely to contain a "/", the actor type is everything before the first "/", and the name everything after the last one.
+func parseLegacyTarget(target string) (actorType, actorID, name string, err error) {
+	actorType, rest, ok := strings.Cut(target, "/")
+	if ok {
+		idx := strings.LastIndex(rest, "/")
+		if idx >= 0 {
+			actorID, name = rest[:idx], rest[idx+1:]
+		} else {
+			ok = false
+		}
+	}
+	if !ok || actorType == "" || actorID == "" || name == "" {
+		return "", "", "", fmt.Errorf("invalid reminder target '%s'", target)
+	}
+	return actorType, actorID, name, nil
+}
+
+// Adds the columns to the reminders table if they don't exist already.
+// Each column is in the format "<name> <type>".
+func addMissingColumns(ctx context.Context, tx *sql.Tx, columns []string) error {
+	existing, err := tableColumns(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	for _, col := range columns {
+		name, _, _ := strings.Cut(col, " ")
+		if existing[name] {
+			continue
+		}
+		_, err = tx.ExecContext(ctx, "ALTER TABLE reminders ADD COLUMN "+col)
+		if err != nil {
+			return fmt.Errorf("failed to add column %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Returns the names of the columns of the reminders table.
+func tableColumns(ctx context.Context, tx *sql.Tx) (map[string]bool, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT name FROM pragma_table_info('reminders')")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make(map[string]bool)
+	var name string
+	for rows.Next() {
+		err = rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+		res[name] = true
+	}
+	return res, rows.Err()
+}
@@ -0,0 +1,237 @@
+package reminders
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLiteMigrations(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("new database", func(t *testing.T) {
+		db := openTestDB(t, filepath.Join(t.TempDir(), "test.db"))
+		var logs bytes.Buffer
+		store := NewSQLiteStore(db, nil, slog.New(slog.NewJSONHandler(&logs, nil)))
+		require.NoError(t, store.Migrate(ctx))
+		assert.Equal(t, len(sqliteMigrations), schemaVersion(t, db))
+
+		// Migrations are logged with the store's logger
+		assert.Contains(t, logs.String(), `"msg":"Applying migration"`)
+
+		// Migrating again is a no-op
+		require.NoError(t, store.Migrate(ctx))
+		assert.Equal(t, len(sqliteMigrations), schemaVersion(t, db))
+
+		require.NoError(t, store.SaveReminder(ctx, &Reminder{ActorType: "type", ActorID: "id", Name: "name", ExecutionTime: time.Now()}))
+	})
+
+	t.Run("upgrade from baseline schema", func(t *testing.T) {
+		db := openTestDB(t, filepath.Join(t.TempDir(), "test.db"))
+		_, err := db.Exec(`CREATE TABLE reminders (
+				target TEXT NOT NULL PRIMARY KEY,
+				execution_time INTEGER NOT NULL,
+				period INTEGER,
+				ttl INTEGER,
+				data BLOB,
+				lease_time INTEGER NOT NULL
+			);
+			CREATE INDEX execution_time_idx ON reminders (execution_time ASC);
+			CREATE INDEX lease_time_idx ON reminders (lease_time ASC);
+
+			INSERT INTO reminders (target, execution_time, period, ttl, data, lease_time) VALUES
+				('type/id/r1', 1000, 0, -1, '{"x":1}', 0),
+				('type/a/b/r2', 2000, 5000, 10000, NULL, 0);`)
+		require.NoError(t, err)
+
+		var logs bytes.Buffer
+		store := NewSQLiteStore(db, nil, slog.New(slog.NewJSONHandler(&logs, nil)))
+		require.NoError(t, store.Migrate(ctx))
+		assert.Equal(t, len(sqliteMigrations), schemaVersion(t, db))
+
+		// Legacy targets were not escaped, so extra "/" are assumed to be part of the actor ID, and a warning is logged
+		assert.Contains(t, logs.String(), `"level":"WARN"`)
+		assert.Contains(t, logs.String(), `"target":"type/a/b/r2","actorType":"type","actorID":"a/b","name":"r2"`)
+		assert.NotContains(t, logs.String(), `"target":"type/id/r1"`)
+
+		acquired, err := store.AcquireReminders(ctx, AcquireRequest{
+			Now:           time.UnixMilli(5000),
+			Owner:         "owner",
+			FetchAhead:    time.Hour,
+			LeaseDuration: time.Second,
+			BatchSize:     10,
+		})
+		require.NoError(t, err)
+		require.Len(t, acquired, 2)
+		assert.Equal(t, Reminder{
+			ActorType:     "type",
+			ActorID:       "id",
+			Name:          "r1",
+			ExecutionTime: time.UnixMilli(1000),
+			Data:          []byte(`{"x":1}`),
+			LeaseOwner:    "owner",
+			LeaseTime:     5000,
+		}, *acquired[0])
+		assert.Equal(t, Reminder{
+			ActorType:     "type",
+			ActorID:       "a/b",
+			Name:          "r2",
+			ExecutionTime: time.UnixMilli(2000),
+			Period:        5 * time.Second,
+			TTL:           time.UnixMilli(10000),
+			LeaseOwner:    "owner",
+			LeaseTime:     5000,
+		}, *acquired[1])
+	})
+
+	t.Run("upgrade from unversioned schema with actor columns", func(t *testing.T) {
+		db := openTestDB(t, filepath.Join(t.TempDir(), "test.db"))
+		_, err := db.Exec(`CREATE TABLE reminders (
+				target TEXT NOT NULL PRIMARY KEY,
+				actor_type TEXT,
+				actor_id TEXT,
+				name TEXT,
+				execution_time INTEGER NOT NULL,
+				period INTEGER,
+				cron TEXT,
+				time_zone TEXT,
+				repeats INTEGER,
+				ttl INTEGER,
+				data BLOB,
+				lease_owner TEXT,
+				lease_time INTEGER NOT NULL
+			);
+			CREATE INDEX actor_idx ON reminders (actor_type, actor_id);
+
+			INSERT INTO reminders (target, actor_type, actor_id, name, execution_time, cron, lease_time) VALUES
+				('type/id/r1', 'type', 'id', 'r1', 1000, '@daily', 0),
+				('type/id/r2', NULL, NULL, NULL, 2000, NULL, 0);`)
+		require.NoError(t, err)
+
+		require.NoError(t, NewSQLiteStore(db, nil, nil).Migrate(ctx))
+
+		var n int
+		require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM reminders WHERE actor_type = 'type' AND actor_id = 'id'`).Scan(&n))
+		assert.Equal(t, 2, n)
+		var cron string
+		require.NoError(t, db.QueryRow(`SELECT cron FROM reminders WHERE name = 'r1'`).Scan(&cron))
+		assert.Equal(t, "@daily", cron)
+	})
+
+	t.Run("failed migrations are rolled back", func(t *testing.T) {
+		db := openTestDB(t, filepath.Join(t.TempDir(), "test.db"))
+		migrations := []sqliteMigration{
+			sqliteMigrations[0],
+			{
+				name: "failing",
+				fn: func(ctx context.Context, tx *sql.Tx, logger *slog.Logger) error {
+					_, err := tx.ExecContext(ctx, `ALTER TABLE reminders ADD COLUMN foo TEXT`)
+					require.NoError(t, err)
+					return errors.New("simulated")
+				},
+			},
+		}
+		err := runSQLiteMigrations(ctx, db, migrations, slog.Default())
+		require.ErrorContains(t, err, "failed to apply migration 2 (failing)")
+
+		assert.Equal(t, 0, schemaVersion(t, db))
+		var n int
+		require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'reminders'`).Scan(&n))
+		assert.Equal(t, 0, n)
+	})
+
+	t.Run("database is newer than supported", func(t *testing.T) {
+		db := newTestDB(t)
+		err := runSQLiteMigrations(ctx, db, sqliteMigrations[:1], slog.Default())
+		require.ErrorContains(t, err, "newer than the latest supported version")
+	})
+
+	t.Run("concurrent migrations", func(t *testing.T) {
+		// Add a slow migration that counts how many times it's applied
+		var applied atomic.Int32
+		migrations := append([]sqliteMigration{}, sqliteMigrations...)
+		migrations = append(migrations, sqliteMigration{
+			name: "slow",
+			fn: func(ctx context.Context, tx *sql.Tx, logger *slog.Logger) error {
+				applied.Add(1)
+				time.Sleep(100 * time.Millisecond)
+				return nil
+			},
+		})
+
+		// Each goroutine uses its own connection pool, like separate processes would
+		path := filepath.Join(t.TempDir(), "test.db")
+		const n = 5
+		errs := make([]error, n)
+		var wg sync.WaitGroup
+		wg.Add(n)
+		for i := 0; i < n; i++ {
+			db := openTestDB(t, path)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = runSQLiteMigrations(ctx, db, migrations, slog.Default())
+			}(i)
+		}
+		wg.Wait()
+
+		for _, err := range errs {
+			require.NoError(t, err)
+		}
+		assert.Equal(t, int32(1), applied.Load())
+		assert.Equal(t, len(migrations), schemaVersion(t, openTestDB(t, path)))
+	})
+}
+
+func TestParseLegacyTarget(t *testing.T) {
+	tests := []struct {
+		target    string
+		actorType string
+		actorID   string
+		name      string
+		wantErr   bool
+	}{
+		{target: "type/id/name", actorType: "type", actorID: "id", name: "name"},
+		{target: "type/a/b/name", actorType: "type", actorID: "a/b", name: "name"},
+		{target: "type/id", wantErr: true},
+		{target: "type", wantErr: true},
+		{target: "type//name", wantErr: true},
+		{target: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			actorType, actorID, name, err := parseLegacyTarget(tt.target)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.actorType, actorType)
+			assert.Equal(t, tt.actorID, actorID)
+			assert.Equal(t, tt.name, name)
+		})
+	}
+}
+
+// Returns the schema version stored in the metadata table, or 0 if not set.
+func schemaVersion(t *testing.T, db *sql.DB) int {
+	t.Helper()
+
+	var version int
+	err := db.QueryRow(`SELECT value FROM `+sqliteMetadataTable+` WHERE key = ?`, sqliteSchemaVersionKey).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0
+	}
+	require.NoError(t, err)
+	return version
+}
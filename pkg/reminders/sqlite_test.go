@@ -0,0 +1,189 @@
+package reminders
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+)
+
+// Example W3C traceparent
+const testTraceParent = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+
+func TestSQLiteStore(t *testing.T) {
+	store := NewSQLiteStore(newTestDB(t), nil, nil)
+	ctx := context.Background()
+	now := time.Now()
+
+	acquireReq := AcquireRequest{
+		Now:           now,
+		Owner:         "owner1",
+		FetchAhead:    5 * time.Second,
+		LeaseDuration: 30 * time.Second,
+		BatchSize:     10,
+	}
+
+	t.Run("save and acquire reminders", func(t *testing.T) {
+		require.NoError(t, store.SaveReminder(ctx, &Reminder{ActorType: "type", ActorID: "a/b", Name: "data", ExecutionTime: now.Add(time.Second), Data: json.RawMessage(`{"x":1}`), TraceParent: testTraceParent}))
+		require.NoError(t, store.SaveReminder(ctx, &Reminder{ActorType: "type", ActorID: "id", Name: "nodata", ExecutionTime: now.Add(2 * time.Second), Period: time.Minute, Repeats: 3}))
+		require.NoError(t, store.SaveReminder(ctx, &Reminder{ActorType: "type", ActorID: "id", Name: "later", ExecutionTime: now.Add(time.Hour)}))
+
+		acquired, err := store.AcquireReminders(ctx, acquireReq)
+		require.NoError(t, err)
+		require.Len(t, acquired, 2)
+
+		assert.Equal(t, "a/b", acquired[0].ActorID)
+		assert.JSONEq(t, `{"x":1}`, string(acquired[0].Data))
+		assert.Equal(t, "owner1", acquired[0].LeaseOwner)
+		assert.Equal(t, now.UnixMilli(), acquired[0].LeaseTime)
+		assert.Equal(t, testTraceParent, acquired[0].TraceParent)
+
+		// Reminders saved without data are acquired with no data
+		assert.Equal(t, "nodata", acquired[1].Name)
+		assert.Empty(t, acquired[1].Data)
+		assert.Equal(t, time.Minute, acquired[1].Period)
+		assert.Equal(t, 3, acquired[1].Repeats)
+		assert.Empty(t, acquired[1].TraceParent)
+
+		// Leased reminders are not acquired again
+		acquired, err = store.AcquireReminders(ctx, acquireReq)
+		require.NoError(t, err)
+		assert.Empty(t, acquired)
+
+		leases, err := store.ListLeases(ctx)
+		require.NoError(t, err)
+		assert.Len(t, leases, 2)
+	})
+
+	t.Run("renew lease and complete reminders", func(t *testing.T) {
+		r := &Reminder{ActorType: "type", ActorID: "id", Name: "nodata", LeaseOwner: "owner1", LeaseTime: now.UnixMilli()}
+		leaseTime, err := store.RenewLease(ctx, r, now.Add(time.Second))
+		require.NoError(t, err)
+
+		// The old lease token is not valid anymore
+		_, err = store.RenewLease(ctx, r, now.Add(time.Second))
+		require.ErrorIs(t, err, ErrLeaseLost)
+
+		r.LeaseTime = leaseTime
+		require.NoError(t, store.CompleteReminder(ctx, r, &Reminder{ExecutionTime: now.Add(3 * time.Second), Repeats: 2}))
+
+		// Rescheduled reminder can be acquired again
+		acquired, err := store.AcquireReminders(ctx, acquireReq)
+		require.NoError(t, err)
+		require.Len(t, acquired, 1)
+		assert.Equal(t, 2, acquired[0].Repeats)
+		assert.Equal(t, now.Add(3*time.Second).UnixMilli(), acquired[0].ExecutionTime.UnixMilli())
+
+		require.NoError(t, store.CompleteReminder(ctx, acquired[0], nil))
+		err = store.CompleteReminder(ctx, acquired[0], nil)
+		require.ErrorIs(t, err, ErrLeaseLost)
+	})
+
+	t.Run("release leases", func(t *testing.T) {
+		r := &Reminder{ActorType: "type", ActorID: "a/b", Name: "data", LeaseOwner: "owner1", LeaseTime: now.UnixMilli()}
+		require.NoError(t, store.ReleaseLeases(ctx, []*Reminder{r}))
+
+		acquired, err := store.AcquireReminders(ctx, acquireReq)
+		require.NoError(t, err)
+		require.Len(t, acquired, 1)
+		assert.Equal(t, "data", acquired[0].Name)
+	})
+
+	t.Run("delete reminders", func(t *testing.T) {
+		deleted, err := store.DeleteReminder(ctx, &Reminder{ActorType: "type", ActorID: "a/b", Name: "data"})
+		require.NoError(t, err)
+		assert.True(t, deleted)
+
+		deleted, err = store.DeleteReminder(ctx, &Reminder{ActorType: "type", ActorID: "a/b", Name: "data"})
+		require.NoError(t, err)
+		assert.False(t, deleted)
+	})
+
+	t.Run("filter by actor", func(t *testing.T) {
+		require.NoError(t, store.SaveReminder(ctx, &Reminder{ActorType: "type1", ActorID: "id1", Name: "r", ExecutionTime: now}))
+		require.NoError(t, store.SaveReminder(ctx, &Reminder{ActorType: "type1", ActorID: "id2", Name: "r", ExecutionTime: now}))
+		require.NoError(t, store.SaveReminder(ctx, &Reminder{ActorType: "type2", ActorID: "id1", Name: "r", ExecutionTime: now}))
+
+		req := acquireReq
+		req.Actors = []ActorFilter{{ActorType: "type1", ActorIDs: []string{"id2"}}, {ActorType: "type2"}}
+		acquired, err := store.AcquireReminders(ctx, req)
+		require.NoError(t, err)
+		require.Len(t, acquired, 2)
+		assert.Equal(t, "type1/id2/r", acquired[0].Key())
+		assert.Equal(t, "type2/id1/r", acquired[1].Key())
+	})
+
+	t.Run("retry reminders", func(t *testing.T) {
+		req := acquireReq
+		req.Actors = []ActorFilter{{ActorType: "retry"}}
+		require.NoError(t, store.SaveReminder(ctx, &Reminder{ActorType: "retry", ActorID: "id", Name: "r", ExecutionTime: now, Period: time.Minute}))
+		acquired, err := store.AcquireReminders(ctx, req)
+		require.NoError(t, err)
+		require.Len(t, acquired, 1)
+		assert.Zero(t, acquired[0].Attempts)
+		assert.True(t, acquired[0].RetryTime.IsZero())
+
+		// Schedule a retry that's not due within fetchAhead and release the lease
+		retry := *acquired[0]
+		retry.Attempts = 1
+		retry.RetryTime = now.Add(time.Minute)
+		retry.FailedAttempts = []FailedAttempt{{Attempt: 1, Time: now, Error: "failed"}}
+		retry.LeaseOwner = ""
+		retry.LeaseTime = 0
+		require.NoError(t, store.RetryReminder(ctx, acquired[0], &retry))
+		err = store.RetryReminder(ctx, acquired[0], &retry)
+		require.ErrorIs(t, err, ErrLeaseLost)
+
+		// The reminder is acquired again only once the retry is due, with its retry state
+		acquired, err = store.AcquireReminders(ctx, req)
+		require.NoError(t, err)
+		assert.Empty(t, acquired)
+		req.Now = now.Add(time.Minute)
+		acquired, err = store.AcquireReminders(ctx, req)
+		require.NoError(t, err)
+		require.Len(t, acquired, 1)
+		assert.Equal(t, 1, acquired[0].Attempts)
+		assert.Equal(t, now.Add(time.Minute).UnixMilli(), acquired[0].RetryTime.UnixMilli())
+		require.Len(t, acquired[0].FailedAttempts, 1)
+		assert.Equal(t, "failed", acquired[0].FailedAttempts[0].Error)
+		assert.Equal(t, now.UnixMilli(), acquired[0].FailedAttempts[0].Time.UnixMilli())
+		assert.Equal(t, now.UnixMilli(), acquired[0].ExecutionTime.UnixMilli())
+
+		// Completing the reminder resets the retry state
+		require.NoError(t, store.CompleteReminder(ctx, acquired[0], &Reminder{ExecutionTime: now.Add(2 * time.Minute)}))
+		req.Now = now.Add(2 * time.Minute)
+		acquired, err = store.AcquireReminders(ctx, req)
+		require.NoError(t, err)
+		require.Len(t, acquired, 1)
+		assert.Zero(t, acquired[0].Attempts)
+		assert.True(t, acquired[0].RetryTime.IsZero())
+		assert.Empty(t, acquired[0].FailedAttempts)
+	})
+}
+
+// Returns a new SQLite database with the reminders table.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db := openTestDB(t, filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, NewSQLiteStore(db, nil, nil).Migrate(context.Background()))
+	return db
+}
+
+// Opens the SQLite database at path, which is closed when the test ends.
+func openTestDB(t *testing.T, path string) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+	return db
+}
@@ -0,0 +1,115 @@
+package reminders
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrLeaseLost is returned by ReminderStore methods when the reminder was deleted or modified, or when its lease was acquired by someone else.
+var ErrLeaseLost = errors.New("reminder was deleted or lease was lost")
+
+// ReminderStore is the interface implemented by the backends that persist reminders.
+type ReminderStore interface {
+	// SaveReminder creates or replaces a reminder.
+	// The reminder is stored with the lease in r.LeaseOwner and r.LeaseTime if set; otherwise, any lease that may exist on it is removed.
+	SaveReminder(ctx context.Context, r *Reminder) error
+	// DeleteReminder removes a reminder.
+	// The returned boolean value will be "true" if a reminder was found and deleted.
+	DeleteReminder(ctx context.Context, r *Reminder) (bool, error)
+	// WatchReminders sends on the returned channel the reminders that are scheduled to be executed within the fetch-ahead interval, after acquiring a lease on them.
+	// Depending on the capabilities of the database, stores can implement this by polling (see WatchByPolling) or by receiving notifications.
+	// The channel is closed when ctx is canceled.
+	WatchReminders(ctx context.Context, req WatchRequest) (<-chan *Reminder, error)
+	// AcquireReminders retrieves the next batch of reminders that are scheduled to be executed (or retried) within the fetch-ahead interval and that do not have an active lease.
+	// The returned reminders are atomically leased by req.Owner, and their LeaseOwner and LeaseTime contain the lease token.
+	// Reminders whose TTL has expired are never returned, and they are removed unless they have an active lease.
+	// If req.Actors is not nil, only reminders for the actors it selects are acquired.
+	AcquireReminders(ctx context.Context, req AcquireRequest) ([]*Reminder, error)
+	// RenewLease renews the lease on a reminder, but only if it is still owned by the caller (i.e. the lease token is still r.LeaseOwner and r.LeaseTime).
+	// It returns the new lease token, which must be used for the next operations on the reminder.
+	// If the lease was lost or the reminder was deleted, ErrLeaseLost is returned.
+	RenewLease(ctx context.Context, r *Reminder, now time.Time) (int64, error)
+	// ReleaseLeases releases the leases on the reminders, so they can be acquired again right away.
+	// Leases that are not owned by the caller anymore (i.e. the lease token is not the reminder's LeaseOwner and LeaseTime) are ignored.
+	ReleaseLeases(ctx context.Context, rs []*Reminder) error
+	// CompleteReminder removes a reminder that has been executed, but only if the lease is still owned by the caller.
+	// If next is not nil, rather than being removed the reminder is rescheduled to next's ExecutionTime (with next's Repeats), its retry state is reset, and its lease is released.
+	// If the lease was lost or the reminder was deleted, ErrLeaseLost is returned.
+	CompleteReminder(ctx context.Context, r *Reminder, next *Reminder) error
+	// RetryReminder records a failed attempt to execute a reminder, but only if the lease is still owned by the caller.
+	// The reminder's Attempts, RetryTime, and FailedAttempts are set to retry's, and the lease is replaced with retry's LeaseOwner and LeaseTime (if LeaseOwner is empty, the lease is released).
+	// If the lease was lost or the reminder was deleted, ErrLeaseLost is returned.
+	RetryReminder(ctx context.Context, r *Reminder, retry *Reminder) error
+	// ExecuteReminder runs executeFn for a reminder that was acquired by the caller, and then completes it: the reminder is removed or, if it repeats, rescheduled to its next iteration.
+	// The store manages the transaction or the lease while executeFn is running. If executeFn returns an error, the reminder is left in the store; if req.RetryPolicy is set, a retry is scheduled and a *RetryError is returned (or, after the last attempt, the reminder is moved to the dead-letter table and the error wraps ErrRetriesExhausted).
+	// If the lease was lost or the reminder was deleted before it could be executed, executeFn is not invoked and ErrLeaseLost is returned.
+	// If the reminder's TTL has expired, executeFn is not invoked and ErrReminderExpired is returned.
+	// If ctx is canceled while executeFn is running and executeFn returns an error, the reminder is left in the store with its lease and a *CanceledError is returned, so the caller can release the lease; if executeFn returns nil, the reminder is completed regardless.
+	// Depending on the capabilities of the database, stores can implement this with a long-running transaction or by renewing the lease (see ExecuteWithLeaseRenewal).
+	ExecuteReminder(ctx context.Context, r *Reminder, req ExecuteRequest, executeFn ExecuteFn) error
+	// ListLeases returns all reminders that have been leased, including leases that may have expired, with their LeaseOwner and LeaseTime.
+	ListLeases(ctx context.Context) ([]*Reminder, error)
+	// DeadLetterReminder atomically removes a reminder that exhausted its attempts and adds dl to the dead-letter table, but only if the lease is still owned by the caller.
+	// If the lease was lost or the reminder was deleted, ErrLeaseLost is returned.
+	DeadLetterReminder(ctx context.Context, r *Reminder, dl *DeadLetter) error
+	// ListDeadLetters returns all reminders in the dead-letter table, in the order they were added.
+	ListDeadLetters(ctx context.Context) ([]*DeadLetter, error)
+	// RequeueDeadLetter atomically removes a reminder from the dead-letter table and saves it again with its original schedule, without a lease or retry state, returning it.
+	// If there's no dead-lettered reminder with the ID, ErrDeadLetterNotFound is returned; if a reminder with the same key exists already, ErrReminderExists is returned.
+	RequeueDeadLetter(ctx context.Context, id int64) (*Reminder, error)
+	// PurgeDeadLetters removes the reminders with the given IDs from the dead-letter table, returning how many were removed.
+	// If ids is nil, all dead-lettered reminders are removed.
+	PurgeDeadLetters(ctx context.Context, ids []int64) (int, error)
+}
+
+// AcquireRequest contains the parameters for ReminderStore.AcquireReminders.
+type AcquireRequest struct {
+	// Current time, which is also used as lease time
+	Now time.Time
+	// ID of the owner of the leases
+	Owner string
+	// Only acquire reminders scheduled to be executed within this time interval from Now
+	FetchAhead time.Duration
+	// Leases older than this are considered expired
+	LeaseDuration time.Duration
+	// Maximum number of reminders to acquire
+	BatchSize int
+	// If not nil, only reminders for these actors are acquired
+	Actors []ActorFilter
+}
+
+// ActorFilter selects reminders for an actor type, and optionally only for some actor IDs.
+type ActorFilter struct {
+	// Actor type
+	ActorType string `json:"actorType"`
+	// If not empty, only reminders for these actor IDs are selected
+	ActorIDs []string `json:"actorIDs,omitempty"`
+}
+
+// MatchesActor returns true if reminders for the given actor are selected by req.Actors.
+func (req AcquireRequest) MatchesActor(actorType string, actorID string) bool {
+	return MatchesActor(req.Actors, actorType, actorID)
+}
+
+// MatchesActor returns true if reminders for the given actor are selected by the filters.
+// If filters is nil, all actors are selected.
+func MatchesActor(filters []ActorFilter, actorType string, actorID string) bool {
+	if filters == nil {
+		return true
+	}
+	for _, f := range filters {
+		if f.ActorType != actorType {
+			continue
+		}
+		if len(f.ActorIDs) == 0 {
+			return true
+		}
+		for _, id := range f.ActorIDs {
+			if id == actorID {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,35 @@
+package reminders
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchesActor(t *testing.T) {
+	filters := []ActorFilter{
+		{ActorType: "type1"},
+		{ActorType: "type2", ActorIDs: []string{"id1", "id2"}},
+	}
+
+	tests := []struct {
+		name      string
+		filters   []ActorFilter
+		actorType string
+		actorID   string
+		want      bool
+	}{
+		{name: "nil filters match all actors", filters: nil, actorType: "any", actorID: "any", want: true},
+		{name: "empty filters match no actor", filters: []ActorFilter{}, actorType: "type1", actorID: "id1", want: false},
+		{name: "actor type with no IDs", filters: filters, actorType: "type1", actorID: "any", want: true},
+		{name: "actor type with matching ID", filters: filters, actorType: "type2", actorID: "id2", want: true},
+		{name: "actor type with other ID", filters: filters, actorType: "type2", actorID: "id3", want: false},
+		{name: "actor type not hosted", filters: filters, actorType: "type3", actorID: "id1", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, MatchesActor(tt.filters, tt.actorType, tt.actorID))
+		})
+	}
+}
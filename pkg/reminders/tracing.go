@@ -0,0 +1,70 @@
+package reminders
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName is the name of the tracer used for the spans about reminders.
+const TracerName = "reminders-demo"
+
+// Key of the W3C Trace Context header that is stored with reminders
+const traceParentKey = "traceparent"
+
+// Propagator used to store the trace context with reminders, in the W3C Trace Context format.
+var traceContextPropagator = propagation.TraceContext{}
+
+// Tracer returns the tracer from tp, or from the global tracer provider if tp is nil.
+func Tracer(tp trace.TracerProvider) trace.Tracer {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return tp.Tracer(TracerName)
+}
+
+// EndSpan ends the span, recording err on it if it's not nil.
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// InjectTraceContext stores the trace context of the span in ctx with the reminder, so spans for its execution can be linked to it.
+// If ctx does not contain a valid span, the trace context is removed.
+func (r *Reminder) InjectTraceContext(ctx context.Context) {
+	carrier := propagation.MapCarrier{}
+	traceContextPropagator.Inject(ctx, carrier)
+	r.TraceParent = carrier.Get(traceParentKey)
+}
+
+// SpanContext returns the span context stored with the reminder.
+// The result is not valid if the reminder has no trace context.
+func (r Reminder) SpanContext() trace.SpanContext {
+	if r.TraceParent == "" {
+		return trace.SpanContext{}
+	}
+	ctx := traceContextPropagator.Extract(context.Background(), propagation.MapCarrier{traceParentKey: r.TraceParent})
+	return trace.SpanContextFromContext(ctx)
+}
+
+// SpanStartOptions returns the options for starting a span about the reminder: the span has the reminder's attributes, and it's linked to the span that created the reminder, if any.
+func (r Reminder) SpanStartOptions() []trace.SpanStartOption {
+	opts := []trace.SpanStartOption{
+		trace.WithAttributes(
+			attribute.String("reminder.actor_type", r.ActorType),
+			attribute.String("reminder.actor_id", r.ActorID),
+			attribute.String("reminder.name", r.Name),
+		),
+	}
+	if sc := r.SpanContext(); sc.IsValid() {
+		opts = append(opts, trace.WithLinks(trace.Link{SpanContext: sc}))
+	}
+	return opts
+}
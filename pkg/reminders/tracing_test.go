@@ -0,0 +1,62 @@
+package reminders
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTraceContext(t *testing.T) {
+	_, tp := newTestTracerProvider()
+
+	t.Run("trace context is stored with the reminder", func(t *testing.T) {
+		ctx, span := tp.Tracer("test").Start(context.Background(), "create")
+		defer span.End()
+
+		r := &Reminder{ActorType: "type", ActorID: "id", Name: "name"}
+		r.InjectTraceContext(ctx)
+		require.NotEmpty(t, r.TraceParent)
+
+		sc := r.SpanContext()
+		require.True(t, sc.IsValid())
+		assert.True(t, sc.IsRemote())
+		assert.Equal(t, span.SpanContext().TraceID(), sc.TraceID())
+		assert.Equal(t, span.SpanContext().SpanID(), sc.SpanID())
+	})
+
+	t.Run("spans for the reminder are linked to the span that created it", func(t *testing.T) {
+		ctx, span := tp.Tracer("test").Start(context.Background(), "create")
+		span.End()
+		r := &Reminder{ActorType: "type", ActorID: "id", Name: "name"}
+		r.InjectTraceContext(ctx)
+
+		_, execSpan := tp.Tracer("test").Start(context.Background(), "execute", r.SpanStartOptions()...)
+		execSpan.End()
+		links := execSpan.(sdktrace.ReadOnlySpan).Links()
+		require.Len(t, links, 1)
+		assert.Equal(t, span.SpanContext().SpanID(), links[0].SpanContext.SpanID())
+	})
+
+	t.Run("no trace context without a span", func(t *testing.T) {
+		r := &Reminder{TraceParent: testTraceParent}
+		r.InjectTraceContext(context.Background())
+		assert.Empty(t, r.TraceParent)
+		assert.False(t, r.SpanContext().IsValid())
+		assert.Len(t, r.SpanStartOptions(), 1)
+	})
+
+	t.Run("invalid trace context is ignored", func(t *testing.T) {
+		r := &Reminder{TraceParent: "not-valid"}
+		assert.False(t, r.SpanContext().IsValid())
+	})
+}
+
+// Returns a tracer provider that records spans in memory, so tests can inspect them.
+func newTestTracerProvider() (*tracetest.InMemoryExporter, *sdktrace.TracerProvider) {
+	exporter := tracetest.NewInMemoryExporter()
+	return exporter, sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+}
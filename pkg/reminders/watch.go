@@ -0,0 +1,162 @@
+package reminders
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	kclock "k8s.io/utils/clock"
+)
+
+// WatchRequest contains the parameters for ReminderStore.WatchReminders.
+type WatchRequest struct {
+	// ID of the owner of the leases
+	Owner string
+	// Only acquire reminders scheduled to be executed within this time interval from now
+	FetchAhead time.Duration
+	// Leases older than this are considered expired
+	LeaseDuration time.Duration
+	// Maximum number of reminders to acquire in each batch
+	BatchSize int
+	// For stores that rely on polling, how often to poll
+	// When a batch is full, the next poll happens right away; when no reminder is due, the interval is doubled up to MaxPollInterval
+	PollInterval time.Duration
+	// For stores that rely on polling, maximum interval between polls when no reminder is due
+	// If zero, polls always happen every PollInterval
+	MaxPollInterval time.Duration
+	// Optional function that returns the actors whose reminders are acquired, which is invoked every time reminders are acquired so the set can change dynamically
+	// If nil, or if it returns nil, reminders for all actors are acquired
+	Actors func() []ActorFilter
+	// Optional function that returns how many more reminders the caller can accept, which is used to cap how many reminders are acquired
+	// No reminder is acquired while it returns zero or less
+	Capacity func() int
+	// Clock used to determine the current time
+	Clock kclock.Clock
+	// Optional tracer provider for the spans about polling; if nil, the global tracer provider is used
+	TracerProvider trace.TracerProvider
+	// Optional logger; if nil, the default logger is used
+	Logger *slog.Logger
+}
+
+// AcquireRequest returns the AcquireRequest for acquiring a batch of reminders at the given time.
+func (req WatchRequest) AcquireRequest(now time.Time) AcquireRequest {
+	res := AcquireRequest{
+		Now:           now,
+		Owner:         req.Owner,
+		FetchAhead:    req.FetchAhead,
+		LeaseDuration: req.LeaseDuration,
+		BatchSize:     req.BatchSize,
+	}
+	if req.Actors != nil {
+		res.Actors = req.Actors()
+	}
+	return res
+}
+
+// WatchByPolling implements ReminderStore.WatchReminders for stores that do not support notifications, by invoking AcquireReminders periodically.
+// If a batch is full, the next one is acquired right away, so backlogs are drained quickly. If no reminder is due, the interval between polls is doubled every time, from req.PollInterval up to req.MaxPollInterval.
+// The returned channel is closed when ctx is canceled.
+// If ctx is canceled while some reminders that were acquired haven't been sent on the channel yet, their leases are released.
+func WatchByPolling(ctx context.Context, store ReminderStore, req WatchRequest) <-chan *Reminder {
+	ch := make(chan *Reminder)
+	logger := req.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	go func() {
+		defer close(ch)
+
+		wait := req.PollInterval
+		for {
+			if wait > 0 {
+				t := req.Clock.NewTimer(wait)
+				select {
+				case <-ctx.Done():
+					// Stop on context cancellation
+					t.Stop()
+					return
+				case <-t.C():
+					// Nop - continue
+				}
+			} else if ctx.Err() != nil {
+				return
+			}
+
+			// Do not acquire more reminders than the caller can accept
+			acquireReq := req.AcquireRequest(req.Clock.Now())
+			if req.Capacity != nil {
+				capacity := req.Capacity()
+				if capacity <= 0 {
+					wait = req.PollInterval
+					continue
+				}
+				if capacity < acquireReq.BatchSize {
+					acquireReq.BatchSize = capacity
+				}
+			}
+
+			// Get the next reminders
+			next, err := acquireTraced(ctx, store, acquireReq, req.TracerProvider)
+			if err != nil {
+				logger.Error("Error retrieving reminders", slog.Any("error", err))
+				wait = req.nextPollInterval(wait, 0, acquireReq.BatchSize)
+				continue
+			}
+			wait = req.nextPollInterval(wait, len(next), acquireReq.BatchSize)
+
+			// Send all reminders on the channel
+			for i, r := range next {
+				select {
+				case ch <- r:
+					// Nop - continue
+				case <-ctx.Done():
+					releaseLeases(store, next[i:], logger)
+					return
+				}
+			}
+		}
+	}()
+	return ch
+}
+
+// Invokes AcquireReminders within a span.
+func acquireTraced(ctx context.Context, store ReminderStore, req AcquireRequest, tp trace.TracerProvider) (res []*Reminder, err error) {
+	ctx, span := Tracer(tp).Start(ctx, "reminders.poll",
+		trace.WithAttributes(attribute.Int("reminders.batch_size", req.BatchSize)),
+	)
+	defer func() {
+		span.SetAttributes(attribute.Int("reminders.acquired", len(res)))
+		EndSpan(span, err)
+	}()
+
+	return store.AcquireReminders(ctx, req)
+}
+
+// Returns how long to wait before the next poll, given the previous interval and how many reminders were acquired out of batchSize.
+func (req WatchRequest) nextPollInterval(prev time.Duration, acquired int, batchSize int) time.Duration {
+	switch {
+	case acquired >= batchSize:
+		// Batch was full, so there may be more reminders that are due
+		return 0
+	case acquired > 0 || req.MaxPollInterval <= req.PollInterval:
+		return req.PollInterval
+	case prev < req.PollInterval:
+		return req.PollInterval
+	case prev*2 > req.MaxPollInterval:
+		return req.MaxPollInterval
+	default:
+		return prev * 2
+	}
+}
+
+// Releases the leases on reminders after the context used to acquire them was canceled.
+func releaseLeases(store ReminderStore, rs []*Reminder, logger *slog.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := store.ReleaseLeases(ctx, rs)
+	if err != nil {
+		logger.Error("Error releasing leases", slog.Int("count", len(rs)), slog.Any("error", err))
+	}
+}
@@ -0,0 +1,198 @@
+package reminders
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func TestNextPollInterval(t *testing.T) {
+	req := WatchRequest{
+		PollInterval:    time.Second,
+		MaxPollInterval: 5 * time.Second,
+	}
+
+	tests := []struct {
+		name     string
+		req      WatchRequest
+		prev     time.Duration
+		acquired int
+		want     time.Duration
+	}{
+		{name: "full batch", req: req, prev: time.Second, acquired: 2, want: 0},
+		{name: "partial batch", req: req, prev: 4 * time.Second, acquired: 1, want: time.Second},
+		{name: "empty batch backs off", req: req, prev: time.Second, acquired: 0, want: 2 * time.Second},
+		{name: "empty batch after full batch", req: req, prev: 0, acquired: 0, want: time.Second},
+		{name: "backoff is capped", req: req, prev: 4 * time.Second, acquired: 0, want: 5 * time.Second},
+		{name: "no backoff", req: WatchRequest{PollInterval: time.Second}, prev: time.Second, acquired: 0, want: time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.req.nextPollInterval(tt.prev, tt.acquired, 2))
+		})
+	}
+}
+
+func TestWatchByPolling(t *testing.T) {
+	newRequest := func(clock *clocktesting.FakeClock) WatchRequest {
+		return WatchRequest{
+			BatchSize:       2,
+			PollInterval:    time.Second,
+			MaxPollInterval: 4 * time.Second,
+			Clock:           clock,
+		}
+	}
+
+	// Receives from the channel until no reminder arrives for 200ms
+	receive := func(ch <-chan *Reminder) []string {
+		names := []string{}
+		for {
+			select {
+			case r := <-ch:
+				names = append(names, r.Name)
+			case <-time.After(200 * time.Millisecond):
+				return names
+			}
+		}
+	}
+
+	t.Run("backlog is drained without waiting", func(t *testing.T) {
+		clock := clocktesting.NewFakeClock(time.Now())
+		store := newBacklogStore(5)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		ch := WatchByPolling(ctx, store, newRequest(clock))
+
+		// All reminders are received after a single poll interval
+		assert.Eventually(t, clock.HasWaiters, time.Second, 10*time.Millisecond)
+		clock.Step(time.Second)
+		assert.Equal(t, []string{"r0", "r1", "r2", "r3", "r4"}, receive(ch))
+		assert.Equal(t, []int{2, 2, 1}, store.batches())
+	})
+
+	t.Run("poll interval backs off when nothing is due", func(t *testing.T) {
+		clock := clocktesting.NewFakeClock(time.Now())
+		store := newBacklogStore(0)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		WatchByPolling(ctx, store, newRequest(clock))
+
+		// Polls happen after 1s, 2s, 4s, 4s
+		for i, d := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+			assert.Eventually(t, clock.HasWaiters, time.Second, 10*time.Millisecond)
+			clock.Step(d - time.Millisecond)
+			time.Sleep(20 * time.Millisecond)
+			require.Len(t, store.batches(), i, "poll happened too early")
+			clock.Step(time.Millisecond)
+			assert.Eventually(t, func() bool {
+				return len(store.batches()) == i+1
+			}, time.Second, 10*time.Millisecond)
+		}
+	})
+
+	t.Run("reminders are not acquired beyond capacity", func(t *testing.T) {
+		clock := clocktesting.NewFakeClock(time.Now())
+		store := newBacklogStore(5)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		// Capacity is reduced as reminders are acquired, and then it stays at zero
+		var lock sync.Mutex
+		capacities := []int{3, 1}
+		req := newRequest(clock)
+		req.Capacity = func() int {
+			lock.Lock()
+			defer lock.Unlock()
+			if len(capacities) == 0 {
+				return 0
+			}
+			c := capacities[0]
+			capacities = capacities[1:]
+			return c
+		}
+		ch := WatchByPolling(ctx, store, req)
+
+		// The second batch is limited by the capacity, and then no more reminders are acquired
+		assert.Eventually(t, clock.HasWaiters, time.Second, 10*time.Millisecond)
+		clock.Step(time.Second)
+		assert.Equal(t, []string{"r0", "r1", "r2"}, receive(ch))
+		assert.Equal(t, []int{2, 1}, store.batches())
+
+		assert.Eventually(t, clock.HasWaiters, time.Second, 10*time.Millisecond)
+		clock.Step(time.Second)
+		assert.Empty(t, receive(ch))
+		assert.Equal(t, []int{2, 1}, store.batches())
+	})
+
+	t.Run("polls are traced", func(t *testing.T) {
+		clock := clocktesting.NewFakeClock(time.Now())
+		store := newBacklogStore(3)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		exporter, tp := newTestTracerProvider()
+		req := newRequest(clock)
+		req.TracerProvider = tp
+		ch := WatchByPolling(ctx, store, req)
+
+		assert.Eventually(t, clock.HasWaiters, time.Second, 10*time.Millisecond)
+		clock.Step(time.Second)
+		assert.Equal(t, []string{"r0", "r1", "r2"}, receive(ch))
+
+		spans := exporter.GetSpans()
+		require.Len(t, spans, 2)
+		for i, acquired := range []int64{2, 1} {
+			assert.Equal(t, "reminders.poll", spans[i].Name)
+			assert.Contains(t, spans[i].Attributes, attribute.Int64("reminders.acquired", acquired))
+		}
+	})
+}
+
+// Store that returns reminders from a backlog, embedding ReminderStore so only the methods used by WatchByPolling need to be implemented.
+type backlogStore struct {
+	ReminderStore
+
+	lock     sync.Mutex
+	backlog  []*Reminder
+	acquired []int
+}
+
+func newBacklogStore(n int) *backlogStore {
+	s := &backlogStore{}
+	for i := 0; i < n; i++ {
+		s.backlog = append(s.backlog, &Reminder{Name: "r" + strconv.Itoa(i)})
+	}
+	return s
+}
+
+func (s *backlogStore) AcquireReminders(ctx context.Context, req AcquireRequest) ([]*Reminder, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	n := req.BatchSize
+	if n > len(s.backlog) {
+		n = len(s.backlog)
+	}
+	res := s.backlog[:n]
+	s.backlog = s.backlog[n:]
+	s.acquired = append(s.acquired, n)
+	return res, nil
+}
+
+func (s *backlogStore) ReleaseLeases(ctx context.Context, rs []*Reminder) error {
+	return nil
+}
+
+// Returns the size of the batches that were acquired.
+func (s *backlogStore) batches() []int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]int{}, s.acquired...)
+}
@@ -2,56 +2,140 @@ package main
 
 import (
 	"context"
-	"database/sql"
 	"errors"
 	"fmt"
-	"log"
-	"strings"
+	"log/slog"
+	"sync"
 	"time"
 
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	kclock "k8s.io/utils/clock"
 
 	"reminders-demo/pkg/reminders"
 )
 
-const (
-	// How often to poll for new rows
-	pollInterval = 2500 * time.Millisecond
-	// When fetching reminders, only look for those scheduled to be executed within this time interval
-	fetchAhead = 5 * time.Second
-	// Lease duration
-	leaseDuration = 30 * time.Second
-	// Maximum number of reminders fetched in batch in each iteration
-	batchSize = 2
-)
-
 type Reminders struct {
-	db        *sql.DB
+	store     reminders.ReminderStore
 	processor *reminders.Processor[*reminders.Reminder]
+	clock     kclock.Clock
+	executeFn func(ctx context.Context, r *reminders.Reminder) error
+	metrics   *reminders.Metrics
+	logger    *slog.Logger
+
+	// Tracer provider for the spans about reminders; if nil, the global tracer provider is used
+	tracerProvider trace.TracerProvider
+
+	opts Options
+
+	// Unique ID of this instance, used as owner of the leases
+	ownerID string
+
+	// Actors hosted by this instance, whose reminders are acquired; nil means all actors
+	actors     []reminders.ActorFilter
+	actorsLock sync.RWMutex
+
+	// Reminders whose execution was canceled while stopping, with their current lease token, whose leases are released by Close
+	canceled     []*reminders.Reminder
+	canceledLock sync.Mutex
 }
 
-func NewReminders(db *sql.DB) *Reminders {
+// NewReminders returns a new Reminders object.
+// The options must have been validated with Options.Validate.
+// If metrics is not nil, metrics about the queue and the execution of reminders are recorded.
+// If tracerProvider is nil, spans are sent to the global tracer provider.
+// If logger is nil, the default logger is used.
+func NewReminders(store reminders.ReminderStore, clock kclock.Clock, opts Options, metrics *reminders.Metrics, tracerProvider trace.TracerProvider, logger *slog.Logger) *Reminders {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	ownerID := uuid.NewString()
 	r := &Reminders{
-		db: db,
+		store:          store,
+		clock:          clock,
+		executeFn:      executeReminder,
+		metrics:        metrics,
+		logger:         logger.With(slog.String("instance", ownerID)),
+		tracerProvider: tracerProvider,
+		opts:           opts,
+		ownerID:        ownerID,
+	}
+	if opts.AppAddress != "" {
+		r.executeFn = reminders.NewHTTPDelivery(opts.AppAddress, opts.AppTimeout).Deliver
+	}
+	procOpts := reminders.ProcessorOptions[*reminders.Reminder]{
+		Logger:                r.logger,
+		OnResult:              r.onExecuted,
+		MaxConcurrent:         opts.MaxConcurrent,
+		MaxConcurrentPerGroup: opts.MaxConcurrentPerActorType,
+		GroupFn: func(reminder *reminders.Reminder) string {
+			return reminder.ActorType
+		},
+		MaxPending: opts.MaxPending,
+		OnRejected: r.onRejected,
+	}
+	if opts.SaturationPolicy == "release" {
+		procOpts.Saturation = reminders.SaturationReject
 	}
-	r.processor = reminders.NewProcessor[*reminders.Reminder](r.executeReminder, kclock.RealClock{})
+	r.processor = reminders.NewProcessorWithContext[*reminders.Reminder](r.executeReminder, clock, procOpts)
+	metrics.SetQueueLengthFunc(r.processor.Len)
 	return r
 }
 
 // AddReminder adds a reminder to be executed.
-func (r *Reminders) AddReminder(ctx context.Context, reminder *reminders.Reminder) error {
-	// TODO (not for the demo): if the reminder's ExecutionTime is < fetchAhead, store with a lease right away and enqueue this reminder in the current process
-
-	q := `INSERT OR REPLACE INTO reminders
-			(target, execution_time, period, ttl, data, lease_time)
-		VALUES (?, ?, ?, ?, ?, 0)`
-	_, err := r.db.ExecContext(ctx, q,
-		reminder.Key(),
-		reminder.ExecutionTime.UnixMilli(),
-		reminder.Period.Milliseconds(),
-		reminder.TTL.UnixMilli(),
-		reminder.Data,
-	)
+func (r *Reminders) AddReminder(ctx context.Context, reminder *reminders.Reminder) (err error) {
+	ctx, span := r.tracer().Start(ctx, "reminders.add", reminder.SpanStartOptions()...)
+	defer func() {
+		reminders.EndSpan(span, err)
+	}()
+
+	err = reminder.Validate()
+	if err != nil {
+		return err
+	}
+	if r.opts.MaxDataSize > 0 && len(reminder.Data) > r.opts.MaxDataSize {
+		return fmt.Errorf("%w: size is %d bytes, but the maximum allowed is %d bytes", reminders.ErrDataTooLarge, len(reminder.Data), r.opts.MaxDataSize)
+	}
+
+	now := r.clock.Now()
+
+	// Work on a copy, so the caller's object is not modified
+	saved := *reminder
+
+	// If the reminder has a cron schedule and no execution time, schedule the first execution according to the cron schedule
+	if saved.ExecutionTime.IsZero() && saved.Cron != "" {
+		saved.ExecutionTime, err = saved.NextCronTime(now)
+		if err != nil {
+			return err
+		}
+	}
+
+	// If the reminder is scheduled within fetchAhead, store it with a lease owned by this instance, so we can enqueue it right away without waiting for the next poll
+	// Otherwise, the reminder is stored without a lease
+	// The trace context is stored with the reminder, so spans for its execution can be linked to this one
+	saved.LeaseOwner = ""
+	saved.LeaseTime = 0
+	saved.InjectTraceContext(ctx)
+	enqueue := r.opts.LocalEnqueue &&
+		saved.ExecutionTime.Sub(now) < r.opts.FetchAhead &&
+		reminders.MatchesActor(r.hostedActors(), saved.ActorType, saved.ActorID)
+	if enqueue {
+		saved.LeaseOwner = r.ownerID
+		saved.LeaseTime = now.UnixMilli()
+	}
+
+	err = r.store.SaveReminder(ctx, &saved)
+	if err != nil {
+		return err
+	}
+
+	if enqueue {
+		// Enqueueing replaces the reminder if it was already in our queue
+		// If it fails, the lease is released so other instances can pick it up
+		r.enqueueReminders(ctx, []*reminders.Reminder{&saved})
+		return nil
+	}
 
 	// Remove the reminder from the processor in case was an existing one that was replaced and it's currently in our queue
 	err = r.processor.Dequeue(reminder)
@@ -59,23 +143,53 @@ func (r *Reminders) AddReminder(ctx context.Context, reminder *reminders.Reminde
 		return err
 	}
 
-	return err
+	return nil
+}
+
+// SetHostedActors sets the actors hosted by this instance: only reminders for these actors are acquired from now on.
+// If actors is nil, reminders for all actors are acquired.
+// Reminders for actors that are no longer hosted are removed from the queue and their leases are released, so other instances can pick them up; executions in progress are not affected.
+func (r *Reminders) SetHostedActors(actors []reminders.ActorFilter) {
+	if actors != nil {
+		actors = append(make([]reminders.ActorFilter, 0, len(actors)), actors...)
+	}
+
+	r.actorsLock.Lock()
+	r.actors = actors
+	r.actorsLock.Unlock()
+
+	if actors == nil {
+		return
+	}
+	removed, err := r.processor.DequeueFunc(func(reminder *reminders.Reminder) bool {
+		return !reminders.MatchesActor(actors, reminder.ActorType, reminder.ActorID)
+	})
+	if err != nil {
+		// The processor is stopped, and leases on queued reminders are released by Close
+		return
+	}
+	if len(removed) > 0 {
+		r.logger.Info("Removing reminders for actors that are no longer hosted", slog.Int("count", len(removed)))
+		r.releaseLeases(removed)
+	}
+}
+
+// Returns the actors hosted by this instance.
+func (r *Reminders) hostedActors() []reminders.ActorFilter {
+	r.actorsLock.RLock()
+	defer r.actorsLock.RUnlock()
+	return r.actors
 }
 
 // DeleteReminder removes a reminder.
 func (r *Reminders) DeleteReminder(ctx context.Context, reminder *reminders.Reminder) error {
 	// Delete from the database
-	q := `DELETE FROM reminders WHERE target = ?`
-	res, err := r.db.ExecContext(ctx, q, reminder.Key())
-	if err != nil {
-		return err
-	}
-	n, err := res.RowsAffected()
+	deleted, err := r.store.DeleteReminder(ctx, reminder)
 	if err != nil {
 		return err
 	}
-	if n == 0 {
-		log.Printf("Reminder %s was not deleted", reminder.Key())
+	if !deleted {
+		r.logger.Info("Reminder was not deleted because it does not exist", slog.Any("reminder", reminder))
 	}
 
 	// Remove the reminder from the processor in case it is in our queue
@@ -87,146 +201,204 @@ func (r *Reminders) DeleteReminder(ctx context.Context, reminder *reminders.Remi
 	return nil
 }
 
-func (r *Reminders) executeReminder(reminder *reminders.Reminder) {
-	err := r.doExecuteReminder(reminder)
-	if err != nil {
-		log.Printf("Error while attempting to execute reminder: %v", err)
-	}
-}
+// Executes a reminder, invoked by the processor when it's due.
+// The context is canceled when the processor is stopped.
+func (r *Reminders) executeReminder(ctx context.Context, reminder *reminders.Reminder) (err error) {
+	// Each execution has its own trace, which is linked to the span that created the reminder
+	ctx, span := r.tracer().Start(ctx, "reminders.execute", reminder.SpanStartOptions()...)
+	defer func() {
+		reminders.EndSpan(span, err)
+	}()
 
-func (r *Reminders) doExecuteReminder(reminder *reminders.Reminder) error {
-	// Delete the row from the database but only if it hasn't been modified yet
-	tx, err := r.db.Begin()
-	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
+	// The store manages the lease while the reminder is executed, and then removes or reschedules the reminder
+	// If the reminder cannot be delivered, it's not completed, and the store schedules a retry with exponential backoff
+	err = r.store.ExecuteReminder(ctx, reminder, r.executeRequest(), func(ctx context.Context) error {
+		// Lag is measured when the reminder is actually executed, after the lease has been renewed
+		// For retries, lag is measured from the time the retry was scheduled at
+		start := r.clock.Now()
+		lag := start.Sub(reminder.ScheduledTime())
+		span.SetAttributes(attribute.Int64("reminders.lag_ms", lag.Milliseconds()))
+		r.logger.Debug("Executing reminder", slog.Any("reminder", reminder), slog.Duration("lag", lag))
+		execErr := r.executeFn(ctx, reminder)
+		r.metrics.ObserveExecution(lag, r.clock.Since(start))
+		return execErr
+	})
+	var retryErr *reminders.RetryError
+	switch {
+	case errors.As(err, &retryErr):
+		span.AddEvent("retry scheduled", trace.WithAttributes(
+			attribute.Int("reminders.attempts", retryErr.Reminder.Attempts),
+			attribute.String("reminders.retry_time", retryErr.Reminder.RetryTime.Format(time.RFC3339Nano)),
+		))
+		return fmt.Errorf("error executing reminder: %w", err)
+	case errors.Is(err, reminders.ErrRetriesExhausted):
+		r.metrics.IncDeadLettered()
+		span.AddEvent("moved to dead-letter table")
+		return fmt.Errorf("error executing reminder: %w", err)
+	case errors.Is(err, reminders.ErrLeaseLost):
+		r.metrics.IncLostLeases(reminders.LostLeaseBeforeExecution)
+		// If the reminder was either deleted by another process, or we somehow lost the lease, it is not executed
+		r.logger.Warn("Reminder cannot be executed because we lost the lease or the reminder was deleted", slog.Any("reminder", reminder))
+		span.AddEvent("lease lost")
+		return nil
+	case errors.Is(err, reminders.ErrReminderExpired):
+		r.logger.Info("Reminder has expired and was not executed", slog.Any("reminder", reminder))
+		span.AddEvent("reminder expired")
+		return nil
+	case errors.Is(err, reminders.ErrLeaseLostDuringExecution):
+		r.metrics.IncLostLeases(reminders.LostLeaseDuringExecution)
+		return fmt.Errorf("error executing reminder: %w", err)
+	case err != nil:
+		return fmt.Errorf("error executing reminder: %w", err)
 	}
-	// Automatically rollback
-	defer tx.Rollback()
+	return nil
+}
 
-	// TODO: If the reminder repeats, rather than deleting it, update its execution_time
-	q := `DELETE FROM reminders
-		WHERE target = ?
-			AND lease_time = ?`
-	res, err := tx.ExecContext(context.TODO(), q, reminder.Key(), reminder.LeaseTime)
-	if err != nil {
-		return fmt.Errorf("failed to execute query: %w", err)
+// Invoked by the processor with the outcome of each execution.
+func (r *Reminders) onExecuted(res reminders.ExecutionResult[*reminders.Reminder]) {
+	if res.Err == nil {
+		return
 	}
-	n, err := res.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("failed to count affected rows: %w", err)
+	var canceledErr *reminders.CanceledError
+	if errors.As(res.Err, &canceledErr) {
+		r.logger.Info("Execution of reminder was canceled because the processor was stopped", slog.Any("reminder", res.Item))
+		r.canceledLock.Lock()
+		r.canceled = append(r.canceled, canceledErr.Reminder)
+		r.canceledLock.Unlock()
+		return
 	}
 
-	// If no rows were affected, it means that the reminder was either deleted by another process, or we somehow lost the lease
-	// In either case, do not execute it
-	if n == 0 {
-		log.Printf("Reminder %s cannot be executed because we lost the lease or the reminder was deleted", reminder.Key())
-		return nil
+	r.logger.Error("Error while attempting to execute reminder", slog.Any("reminder", res.Item), slog.Any("error", res.Err))
+
+	// If the retry is due soon and we still own the lease, retry the reminder from our queue rather than waiting for it to be acquired again
+	var retryErr *reminders.RetryError
+	if errors.As(res.Err, &retryErr) && retryErr.Reminder.LeaseOwner != "" {
+		r.enqueueReminders(context.Background(), []*reminders.Reminder{retryErr.Reminder})
 	}
+}
 
-	// Execute the reminder
-	executeReminder(reminder)
+// RequeueDeadLetter moves a reminder from the dead-letter table back to the reminders table, with its original schedule.
+// The reminder is acquired by polling like any other; if its execution time is in the past, it's executed right away.
+func (r *Reminders) RequeueDeadLetter(ctx context.Context, id int64) error {
+	reminder, err := r.store.RequeueDeadLetter(ctx, id)
+	if err != nil {
+		return err
+	}
+	r.logger.Info("Requeued dead-lettered reminder", slog.Int64("id", id), slog.Any("reminder", reminder))
+	return nil
+}
 
-	// Now commit the transaction
-	err = tx.Commit()
+// WatchReminders receives the reminders that are due soon from the store, and adds them to the processor's queue.
+// This is a blocking function that should be called in a background goroutine; it returns when ctx is canceled.
+func (r *Reminders) WatchReminders(ctx context.Context) error {
+	ch, err := r.store.WatchReminders(ctx, r.watchRequest())
 	if err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
+		return fmt.Errorf("failed to watch reminders: %w", err)
 	}
 
+	for reminder := range ch {
+		r.enqueueReminders(ctx, []*reminders.Reminder{reminder})
+	}
 	return nil
 }
 
-// PollReminders periodically polls the database for the next reminder.
-// This is a blocking function that should be called in a background goroutine.
-func (r *Reminders) PollReminders(ctx context.Context) {
-	t := time.NewTicker(pollInterval)
-	defer t.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			// Stop on context cancellation
+// Adds the reminders to the processor's queue.
+// If a reminder cannot be enqueued, the leases on it and on all the following ones are released so other sidecars can pick them up.
+func (r *Reminders) enqueueReminders(ctx context.Context, next []*reminders.Reminder) {
+	for i, reminder := range next {
+		// Add the reminder to the queue
+		_, span := r.tracer().Start(ctx, "reminders.enqueue", reminder.SpanStartOptions()...)
+		err := r.processor.Enqueue(reminder)
+		reminders.EndSpan(span, err)
+		if err != nil {
+			r.logger.Error("Error enqueueing reminder", slog.Any("reminder", reminder), slog.Any("error", err))
+			r.releaseLeases(next[i:])
 			return
+		}
+		r.logger.Info("Enqueued reminder", slog.Any("reminder", reminder))
+	}
+}
 
-		case <-t.C:
-			// Get the next reminers
-			next, err := r.getNextReminders(ctx)
-			if err != nil {
-				log.Printf("Error retrieving reminder: %v", err)
-				break
-			}
-
-			// Enqueue all reminders
-			for _, reminder := range next {
-				// Add the reminder to the queue
-				err = r.processor.Enqueue(&reminder)
-				if err != nil {
-					// TODO: Attempt to release the lease on the reminder
-					log.Printf("Error enqueueing reminder: %v", err)
-					break
-				}
-				log.Printf("Enqueued reminder %s - scheduled for %s", reminder.Key(), reminder.ExecutionTime.Local().Format(time.RFC822))
-			}
+// Invoked by the processor when a due reminder is rejected because the pending buffer is full, and either all workers are busy (with the "release" saturation policy) or its actor type is at its concurrency limit.
+// The lease is released so the reminder can be executed by another sidecar, or by this one once it has room again.
+func (r *Reminders) onRejected(reminder *reminders.Reminder) {
+	// The processor's loop must not block, so release the lease in background
+	go r.releaseLeases([]*reminders.Reminder{reminder})
+}
 
-		}
+// Releases the leases on the reminders, so other sidecars can pick them up right away.
+func (r *Reminders) releaseLeases(rs []*reminders.Reminder) {
+	// Use a separate context because this often happens while shutting down
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := r.store.ReleaseLeases(ctx, rs)
+	if err != nil {
+		r.logger.Error("Error releasing leases", slog.Int("count", len(rs)), slog.Any("error", err))
+		return
 	}
+	r.logger.Info("Released leases on reminders", slog.Int("count", len(rs)))
 }
 
-func (r *Reminders) getNextReminders(ctx context.Context) ([]reminders.Reminder, error) {
-	now := time.Now().UnixMilli()
-
-	// Select the next reminder that is scheduled to be executed within 5s from now and that does not have an active lease
-	// The row is atomically updated to acquire a lease
-	q := `UPDATE reminders
-		SET lease_time = ?
-		WHERE ROWID IN (
-			SELECT ROWID
-			FROM reminders
-			WHERE 
-				execution_time < ?
-				AND lease_time < ?
-			ORDER BY execution_time ASC
-			LIMIT ?
-		)
-		RETURNING target, execution_time, period, ttl, lease_time`
-	dbRes, err := r.db.QueryContext(ctx, q,
-		now, now+fetchAhead.Milliseconds(), now-leaseDuration.Milliseconds(),
-		batchSize,
-	)
+// Close stops processing reminders.
+// Executions in progress are canceled, and this waits for them to return.
+// The leases on all reminders that are still in the queue, and on those whose execution was canceled, are released, so other sidecars can pick them up right away.
+func (r *Reminders) Close(ctx context.Context) error {
+	err := r.processor.Close()
 	if err != nil {
-		// Ignore ErrNoRows
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, nil
-		}
-		return nil, err
-	}
-	defer dbRes.Close()
-
-	// Scan each row in the result
-	res := make([]reminders.Reminder, batchSize)
-	var (
-		rmd           reminders.Reminder
-		i             int
-		target        string
-		executionTime int64
-		period, ttl   int64
-	)
-	for dbRes.Next() {
-		// Scan the row
-		err = dbRes.Scan(&target, &executionTime, &period, &ttl, &rmd.LeaseTime)
-		if err != nil {
-			return nil, err
+		return fmt.Errorf("failed to stop processor: %w", err)
+	}
+
+	// The processor has waited for the canceled executions to return, so they have all been collected by onExecuted
+	queued := r.processor.Drain()
+	r.canceledLock.Lock()
+	canceled := r.canceled
+	r.canceled = nil
+	r.canceledLock.Unlock()
+	if len(queued) == 0 && len(canceled) == 0 {
+		return nil
+	}
+	err = r.store.ReleaseLeases(ctx, append(queued, canceled...))
+	if err != nil {
+		return fmt.Errorf("failed to release leases: %w", err)
+	}
+	r.logger.Info("Released leases on queued reminders and canceled executions", slog.Int("queued", len(queued)), slog.Int("canceled", len(canceled)))
+	return nil
+}
+
+// Returns the parameters for watching reminders.
+func (r *Reminders) watchRequest() reminders.WatchRequest {
+	req := reminders.WatchRequest{
+		Owner:           r.ownerID,
+		FetchAhead:      r.opts.FetchAhead,
+		LeaseDuration:   r.opts.LeaseDuration,
+		BatchSize:       r.opts.BatchSize,
+		PollInterval:    r.opts.PollInterval,
+		MaxPollInterval: r.opts.MaxPollInterval,
+		Actors:          r.hostedActors,
+		Clock:           r.clock,
+		TracerProvider:  r.tracerProvider,
+		Logger:          r.logger,
+	}
+	if r.opts.MaxQueued > 0 {
+		req.Capacity = func() int {
+			return r.opts.MaxQueued - r.processor.Len()
 		}
-		parts := strings.Split(target, "/")
-		rmd.ActorType = parts[0]
-		rmd.ActorID = parts[1]
-		rmd.Name = parts[2]
-		rmd.ExecutionTime = time.Unix(executionTime/1000, (executionTime%1000)*10e6)
-		rmd.Period = time.Duration(period) * time.Millisecond
-		rmd.TTL = time.Unix(ttl/1000, (ttl%1000)*10e6)
+	}
+	return req
+}
 
-		res[i] = rmd
+// Returns the tracer for the spans about reminders.
+func (r *Reminders) tracer() trace.Tracer {
+	return reminders.Tracer(r.tracerProvider)
+}
 
-		i++
+// Returns the parameters for executing reminders.
+func (r *Reminders) executeRequest() reminders.ExecuteRequest {
+	return reminders.ExecuteRequest{
+		LeaseRenewInterval: r.opts.leaseRenewInterval(),
+		Clock:              r.clock,
+		RetryPolicy:        r.opts.retryPolicy(),
+		// Retries within fetchAhead are kept in our queue, just like reminders that are acquired by polling
+		MaxLocalRetryDelay: r.opts.FetchAhead,
 	}
-	return res[:i], nil
 }
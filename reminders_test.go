@@ -0,0 +1,1556 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	kclock "k8s.io/utils/clock"
+	clocktesting "k8s.io/utils/clock/testing"
+
+	"reminders-demo/pkg/reminders"
+)
+
+func TestReminders(t *testing.T) {
+	store := newFakeStore()
+	clock := clocktesting.NewFakeClock(time.Now())
+	rm := newTestReminders(store, clock)
+	defer rm.processor.Close()
+
+	now := clock.Now()
+
+	t.Run("add reminders", func(t *testing.T) {
+		require.NoError(t, rm.AddReminder(context.Background(), newReminder("r1", now.Add(time.Second))))
+		require.NoError(t, rm.AddReminder(context.Background(), newReminder("r2", now.Add(time.Hour))))
+		require.NoError(t, rm.AddReminder(context.Background(), newReminder("r3", now.Add(2*time.Second))))
+
+		assert.Len(t, store.reminders, 3)
+	})
+
+	t.Run("acquire next reminders", func(t *testing.T) {
+		next, err := acquireReminders(rm)
+		require.NoError(t, err)
+		require.Len(t, next, 2)
+		assert.Equal(t, "r1", next[0].Name)
+		assert.Equal(t, "r3", next[1].Name)
+		assert.NotZero(t, next[0].LeaseTime)
+
+		// Reminders with an active lease are not acquired again
+		next, err = acquireReminders(rm)
+		require.NoError(t, err)
+		assert.Empty(t, next)
+	})
+
+	t.Run("execute reminder", func(t *testing.T) {
+		r := store.reminders["myactor/myid/r1"]
+		require.NoError(t, rm.executeReminder(context.Background(), r))
+		assert.NotContains(t, store.reminders, "myactor/myid/r1")
+	})
+
+	t.Run("do not execute reminder with lost lease", func(t *testing.T) {
+		r := *store.reminders["myactor/myid/r3"]
+		r.LeaseTime--
+		require.NoError(t, rm.executeReminder(context.Background(), &r))
+		assert.Contains(t, store.reminders, "myactor/myid/r3")
+	})
+
+	t.Run("replace reminder removes lease", func(t *testing.T) {
+		require.NoError(t, rm.AddReminder(context.Background(), newReminder("r3", now.Add(2*time.Second))))
+		assert.Zero(t, store.reminders["myactor/myid/r3"].LeaseTime)
+	})
+
+	t.Run("delete reminder", func(t *testing.T) {
+		require.NoError(t, rm.DeleteReminder(context.Background(), newReminder("r2", time.Time{})))
+		assert.NotContains(t, store.reminders, "myactor/myid/r2")
+
+		// Deleting a reminder that doesn't exist is not an error
+		require.NoError(t, rm.DeleteReminder(context.Background(), newReminder("r2", time.Time{})))
+	})
+}
+
+func TestRepeatingReminders(t *testing.T) {
+	store := newFakeStore()
+	clock := clocktesting.NewFakeClock(time.Now())
+	rm := newTestReminders(store, clock)
+	defer rm.processor.Close()
+
+	start := clock.Now()
+	r := newReminder("repeating", start.Add(time.Second))
+	r.Period = 10 * time.Second
+	require.NoError(t, rm.AddReminder(context.Background(), r))
+
+	acquireAndExecute := func(t *testing.T) {
+		t.Helper()
+
+		next, err := acquireReminders(rm)
+		require.NoError(t, err)
+		require.Len(t, next, 1)
+		require.NoError(t, rm.executeReminder(context.Background(), next[0]))
+	}
+
+	t.Run("reminder is rescheduled after execution", func(t *testing.T) {
+		clock.Step(time.Second)
+		acquireAndExecute(t)
+
+		require.Contains(t, store.reminders, r.Key())
+		saved := store.reminders[r.Key()]
+		assert.Equal(t, start.Add(11*time.Second).UnixMilli(), saved.ExecutionTime.UnixMilli())
+		assert.Zero(t, saved.LeaseTime)
+	})
+
+	t.Run("next iteration is not acquired before it's due", func(t *testing.T) {
+		next, err := acquireReminders(rm)
+		require.NoError(t, err)
+		assert.Empty(t, next)
+	})
+
+	t.Run("missed iterations are skipped", func(t *testing.T) {
+		// Move the clock ahead by 35s, so the reminder is late and iterations at 21s and 31s are missed
+		clock.Step(35 * time.Second)
+		acquireAndExecute(t)
+
+		require.Contains(t, store.reminders, r.Key())
+		assert.Equal(t, start.Add(41*time.Second).UnixMilli(), store.reminders[r.Key()].ExecutionTime.UnixMilli())
+	})
+
+	t.Run("reminder with repetition count is deleted after the last execution", func(t *testing.T) {
+		clock.Step(5 * time.Second)
+		bounded := newReminder("bounded", clock.Now())
+		bounded.Period = 10 * time.Second
+		bounded.Repeats = 2
+		require.NoError(t, rm.AddReminder(context.Background(), bounded))
+
+		// Make sure the other reminder is not due
+		require.NoError(t, rm.DeleteReminder(context.Background(), r))
+
+		acquireAndExecute(t)
+		require.Contains(t, store.reminders, bounded.Key())
+		assert.Equal(t, 1, store.reminders[bounded.Key()].Repeats)
+
+		clock.Step(10 * time.Second)
+		acquireAndExecute(t)
+		assert.NotContains(t, store.reminders, bounded.Key())
+	})
+
+	t.Run("non-repeating reminder is deleted", func(t *testing.T) {
+		clock.Step(5 * time.Second)
+		require.NoError(t, rm.AddReminder(context.Background(), newReminder(r.Name, clock.Now())))
+		acquireAndExecute(t)
+
+		assert.NotContains(t, store.reminders, r.Key())
+	})
+}
+
+func TestCronReminders(t *testing.T) {
+	store := newFakeStore()
+	clock := clocktesting.NewFakeClock(time.Date(2023, 3, 24, 7, 59, 58, 0, time.UTC))
+	rm := newTestReminders(store, clock)
+	defer rm.processor.Close()
+
+	r := newReminder("cron", time.Time{})
+	r.Cron = "0 9 * * MON-FRI"
+	r.TimeZone = "Europe/Rome"
+
+	t.Run("first execution is scheduled from the cron schedule", func(t *testing.T) {
+		// 09:00 in Rome is 08:00 UTC on this day (CET)
+		require.NoError(t, rm.AddReminder(context.Background(), r))
+		require.Contains(t, store.reminders, r.Key())
+		assert.Equal(t, time.Date(2023, 3, 24, 8, 0, 0, 0, time.UTC).UnixMilli(), store.reminders[r.Key()].ExecutionTime.UnixMilli())
+
+		// The caller's object is not modified
+		assert.True(t, r.ExecutionTime.IsZero())
+	})
+
+	t.Run("reminder is rescheduled after execution", func(t *testing.T) {
+		next, err := acquireReminders(rm)
+		require.NoError(t, err)
+		require.Len(t, next, 1)
+		require.NoError(t, rm.executeReminder(context.Background(), next[0]))
+
+		// Next execution is on Monday, at 09:00 CEST
+		require.Contains(t, store.reminders, r.Key())
+		assert.Equal(t, time.Date(2023, 3, 27, 7, 0, 0, 0, time.UTC).UnixMilli(), store.reminders[r.Key()].ExecutionTime.UnixMilli())
+	})
+
+	t.Run("invalid cron expressions are rejected", func(t *testing.T) {
+		invalid := newReminder("invalid", time.Time{})
+		invalid.Cron = "every day"
+		err := rm.AddReminder(context.Background(), invalid)
+		require.ErrorIs(t, err, reminders.ErrInvalidReminder)
+		assert.NotContains(t, store.reminders, invalid.Key())
+	})
+}
+
+func TestLeaseRenewal(t *testing.T) {
+	store := newFakeStore()
+	clock := clocktesting.NewFakeClock(time.Now())
+	rm := newTestReminders(store, clock)
+	defer rm.processor.Close()
+
+	// Executing reminders blocks until we send a signal
+	executingCh := make(chan *reminders.Reminder)
+	continueCh := make(chan struct{})
+	rm.executeFn = func(ctx context.Context, r *reminders.Reminder) error {
+		executingCh <- r
+		<-continueCh
+		return nil
+	}
+
+	// Starts executing a reminder in background and waits until the execution is in progress
+	startExecution := func(t *testing.T, name string) <-chan error {
+		t.Helper()
+
+		require.NoError(t, rm.AddReminder(context.Background(), newReminder(name, clock.Now())))
+		next, err := acquireReminders(rm)
+		require.NoError(t, err)
+		require.Len(t, next, 1)
+
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- rm.executeReminder(context.Background(), next[0])
+		}()
+		select {
+		case <-executingCh:
+		case <-time.After(time.Second):
+			t.Fatal("reminder was not executed in 1s")
+		}
+		assert.Eventually(t, clock.HasWaiters, time.Second, 10*time.Millisecond)
+		return errCh
+	}
+
+	waitExecutionResult := func(t *testing.T, errCh <-chan error) error {
+		t.Helper()
+
+		close(continueCh)
+		defer func() {
+			continueCh = make(chan struct{})
+		}()
+		select {
+		case err := <-errCh:
+			return err
+		case <-time.After(time.Second):
+			t.Fatal("execution did not complete in 1s")
+		}
+		return nil
+	}
+
+	t.Run("lease is renewed while the reminder is executing", func(t *testing.T) {
+		errCh := startExecution(t, "slow")
+
+		// Move the clock past the lease duration, in steps so the lease is renewed
+		for i := 0; i < 5; i++ {
+			assert.Eventually(t, clock.HasWaiters, time.Second, 10*time.Millisecond)
+			clock.Step(rm.opts.leaseRenewInterval())
+			expectLeaseTime := clock.Now().UnixMilli()
+			assert.Eventually(t, func() bool {
+				r, _ := store.get("myactor/myid/slow")
+				return r.LeaseTime == expectLeaseTime
+			}, time.Second, 10*time.Millisecond)
+		}
+
+		// Reminder cannot be acquired by others
+		next, err := acquireReminders(rm)
+		require.NoError(t, err)
+		assert.Empty(t, next)
+
+		// Complete the execution, which removes the reminder
+		require.NoError(t, waitExecutionResult(t, errCh))
+		_, ok := store.get("myactor/myid/slow")
+		assert.False(t, ok)
+	})
+
+	t.Run("reminder replaced while executing is not removed", func(t *testing.T) {
+		errCh := startExecution(t, "replaced")
+
+		// Replace the reminder, which resets the lease
+		require.NoError(t, rm.AddReminder(context.Background(), newReminder("replaced", clock.Now().Add(time.Hour))))
+		clock.Step(rm.opts.leaseRenewInterval())
+
+		err := waitExecutionResult(t, errCh)
+		require.ErrorIs(t, err, reminders.ErrLeaseLostDuringExecution)
+
+		// The new reminder is still in the store
+		r, ok := store.get("myactor/myid/replaced")
+		require.True(t, ok)
+		assert.Equal(t, clock.Now().Add(time.Hour-rm.opts.leaseRenewInterval()).UnixMilli(), r.ExecutionTime.UnixMilli())
+	})
+}
+
+func TestReleaseLeases(t *testing.T) {
+	store := newFakeStore()
+	clock := clocktesting.NewFakeClock(time.Now())
+	rm := newTestReminders(store, clock)
+
+	for _, name := range []string{"r1", "r2"} {
+		require.NoError(t, rm.AddReminder(context.Background(), newReminder(name, clock.Now().Add(time.Second))))
+	}
+
+	t.Run("leases are released on shutdown", func(t *testing.T) {
+		next, err := acquireReminders(rm)
+		require.NoError(t, err)
+		require.Len(t, next, 2)
+		rm.enqueueReminders(context.Background(), next)
+
+		// Change the owner of r2, so the lease is now owned by someone else
+		store.reminders["myactor/myid/r2"].LeaseOwner = "someone-else"
+
+		require.NoError(t, rm.Close(context.Background()))
+
+		r1, _ := store.get("myactor/myid/r1")
+		assert.Zero(t, r1.LeaseTime)
+		r2, _ := store.get("myactor/myid/r2")
+		assert.NotZero(t, r2.LeaseTime)
+		assert.Equal(t, "someone-else", r2.LeaseOwner)
+	})
+
+	t.Run("leases are released when enqueueing fails", func(t *testing.T) {
+		// The processor is stopped, so enqueueing fails
+		store.reminders["myactor/myid/r2"].LeaseTime = 0
+		next, err := acquireReminders(rm)
+		require.NoError(t, err)
+		require.Len(t, next, 2)
+		rm.enqueueReminders(context.Background(), next)
+
+		for _, key := range []string{"myactor/myid/r1", "myactor/myid/r2"} {
+			r, _ := store.get(key)
+			assert.Zero(t, r.LeaseTime)
+		}
+	})
+}
+
+func TestSaturation(t *testing.T) {
+	clock := clocktesting.NewFakeClock(time.Now())
+
+	// Returns a Reminders object whose executions block until the test ends, and its store
+	newReminders := func(t *testing.T, modify func(o *Options)) (*Reminders, *fakeStore) {
+		store := newFakeStore()
+		opts := DefaultOptions()
+		opts.LocalEnqueue = false
+		opts.MaxPending = 0
+		modify(&opts)
+		rm := NewReminders(store, clock, opts, nil, nil, nil)
+
+		releaseCh := make(chan struct{})
+		rm.executeFn = func(ctx context.Context, r *reminders.Reminder) error {
+			<-releaseCh
+			return nil
+		}
+		t.Cleanup(func() {
+			close(releaseCh)
+			rm.processor.Close()
+		})
+		return rm, store
+	}
+
+	// Enqueues two reminders for the same actor type, and asserts that one is executing and the other one is rejected
+	// Both are due at the same time, so either can be the one that is executed
+	assertOneRejected := func(t *testing.T, rm *Reminders, store *fakeStore) {
+		t.Helper()
+
+		for _, name := range []string{"r1", "r2"} {
+			require.NoError(t, rm.AddReminder(context.Background(), newReminder(name, clock.Now())))
+		}
+		next, err := acquireReminders(rm)
+		require.NoError(t, err)
+		require.Len(t, next, 2)
+		rm.enqueueReminders(context.Background(), next)
+
+		var leased []string
+		assert.Eventually(t, func() bool {
+			leased = leased[:0]
+			for _, key := range []string{"myactor/myid/r1", "myactor/myid/r2"} {
+				r, _ := store.get(key)
+				if r.LeaseTime != 0 {
+					leased = append(leased, r.LeaseOwner)
+				}
+			}
+			return len(leased) == 1
+		}, time.Second, 10*time.Millisecond)
+		assert.Equal(t, []string{rm.ownerID}, leased)
+		assert.Equal(t, 1, rm.processor.Executing())
+	}
+
+	t.Run("lease is released when no worker is available", func(t *testing.T) {
+		rm, store := newReminders(t, func(o *Options) {
+			o.MaxConcurrent = 1
+			o.SaturationPolicy = "release"
+		})
+		assertOneRejected(t, rm, store)
+	})
+
+	t.Run("lease is released when the actor type is at its limit", func(t *testing.T) {
+		// With the wait policy, reminders whose actor type is at its limit are released rather than blocking other actor types
+		rm, store := newReminders(t, func(o *Options) {
+			o.MaxConcurrentPerActorType = 1
+			o.SaturationPolicy = "wait"
+		})
+		assertOneRejected(t, rm, store)
+	})
+}
+
+func TestLeaseOwner(t *testing.T) {
+	store := newFakeStore()
+	clock := clocktesting.NewFakeClock(time.Now())
+	rm1 := newTestReminders(store, clock)
+	defer rm1.processor.Close()
+	rm2 := newTestReminders(store, clock)
+	defer rm2.processor.Close()
+	require.NotEqual(t, rm1.ownerID, rm2.ownerID)
+
+	require.NoError(t, rm1.AddReminder(context.Background(), newReminder("r1", clock.Now())))
+
+	// Instance 1 acquires the reminder, then releases the lease
+	next1, err := acquireReminders(rm1)
+	require.NoError(t, err)
+	require.Len(t, next1, 1)
+	assert.Equal(t, rm1.ownerID, next1[0].LeaseOwner)
+	require.NoError(t, store.ReleaseLeases(context.Background(), next1))
+
+	// Instance 2 acquires the reminder in the same millisecond
+	next2, err := acquireReminders(rm2)
+	require.NoError(t, err)
+	require.Len(t, next2, 1)
+	assert.Equal(t, rm2.ownerID, next2[0].LeaseOwner)
+	assert.Equal(t, next1[0].LeaseTime, next2[0].LeaseTime)
+
+	leases, err := store.ListLeases(context.Background())
+	require.NoError(t, err)
+	require.Len(t, leases, 1)
+	assert.Equal(t, rm2.ownerID, leases[0].LeaseOwner)
+
+	// Instance 1 cannot execute the reminder even though the lease time is the same
+	executed := false
+	rm1.executeFn = func(ctx context.Context, r *reminders.Reminder) error {
+		executed = true
+		return nil
+	}
+	require.NoError(t, rm1.executeReminder(context.Background(), next1[0]))
+	assert.False(t, executed)
+	_, ok := store.get("myactor/myid/r1")
+	assert.True(t, ok)
+
+	// Instance 2 can execute it
+	rm2.executeFn = rm1.executeFn
+	require.NoError(t, rm2.executeReminder(context.Background(), next2[0]))
+	assert.True(t, executed)
+	_, ok = store.get("myactor/myid/r1")
+	assert.False(t, ok)
+}
+
+func TestLocalEnqueue(t *testing.T) {
+	store := newFakeStore()
+
+	// Use a real clock to measure latency
+	rm := NewReminders(store, kclock.RealClock{}, DefaultOptions(), nil, nil, nil)
+	defer rm.processor.Close()
+	executeCh := make(chan *reminders.Reminder, 1)
+	rm.executeFn = func(ctx context.Context, r *reminders.Reminder) error {
+		executeCh <- r
+		return nil
+	}
+
+	t.Run("reminders scheduled within fetchAhead are executed without polling", func(t *testing.T) {
+		start := time.Now()
+		r := newReminder("soon", start.Add(100*time.Millisecond))
+		require.NoError(t, rm.AddReminder(context.Background(), r))
+
+		// Reminder is stored with a lease owned by this instance
+		saved, ok := store.get(r.Key())
+		require.True(t, ok)
+		assert.Equal(t, rm.ownerID, saved.LeaseOwner)
+		assert.NotZero(t, saved.LeaseTime)
+
+		select {
+		case executed := <-executeCh:
+			assert.Equal(t, "soon", executed.Name)
+			assert.Less(t, time.Since(start), rm.opts.PollInterval)
+		case <-time.After(rm.opts.PollInterval):
+			t.Fatal("reminder was not executed before the poll interval")
+		}
+
+		assert.Eventually(t, func() bool {
+			_, ok := store.get(r.Key())
+			return !ok
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("reminders scheduled later are not leased", func(t *testing.T) {
+		r := newReminder("later", time.Now().Add(time.Hour))
+		require.NoError(t, rm.AddReminder(context.Background(), r))
+
+		saved, ok := store.get(r.Key())
+		require.True(t, ok)
+		assert.Empty(t, saved.LeaseOwner)
+		assert.Zero(t, saved.LeaseTime)
+	})
+
+	t.Run("local enqueueing can be disabled", func(t *testing.T) {
+		rm.opts.LocalEnqueue = false
+		defer func() {
+			rm.opts.LocalEnqueue = true
+		}()
+
+		r := newReminder("disabled", time.Now().Add(100*time.Millisecond))
+		require.NoError(t, rm.AddReminder(context.Background(), r))
+
+		saved, ok := store.get(r.Key())
+		require.True(t, ok)
+		assert.Zero(t, saved.LeaseTime)
+
+		select {
+		case executed := <-executeCh:
+			t.Fatalf("reminder %s was executed without polling", executed.Name)
+		case <-time.After(500 * time.Millisecond):
+			// All good
+		}
+	})
+}
+
+func TestWatchReminders(t *testing.T) {
+	store := newFakeStore()
+	clock := clocktesting.NewFakeClock(time.Now())
+	rm := newTestReminders(store, clock)
+	defer rm.processor.Close()
+	executeCh := make(chan *reminders.Reminder, 1)
+	rm.executeFn = func(ctx context.Context, r *reminders.Reminder) error {
+		executeCh <- r
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	doneCh := make(chan error, 1)
+	go func() {
+		doneCh <- rm.WatchReminders(ctx)
+	}()
+
+	// Reminder is due after the first poll
+	r := newReminder("watched", clock.Now().Add(rm.opts.PollInterval+time.Second))
+	require.NoError(t, rm.AddReminder(context.Background(), r))
+
+	// After the first poll, the reminder is leased and enqueued
+	assert.Eventually(t, clock.HasWaiters, time.Second, 10*time.Millisecond)
+	clock.Step(rm.opts.PollInterval)
+	assert.Eventually(t, func() bool {
+		saved, _ := store.get(r.Key())
+		return saved.LeaseOwner == rm.ownerID
+	}, time.Second, 10*time.Millisecond)
+
+	// Reminder is executed when it's due
+	select {
+	case executed := <-executeCh:
+		t.Fatalf("reminder %s was executed too early", executed.Name)
+	case <-time.After(100 * time.Millisecond):
+		// All good
+	}
+	clock.Step(time.Second)
+	select {
+	case executed := <-executeCh:
+		assert.Equal(t, "watched", executed.Name)
+	case <-time.After(time.Second):
+		t.Fatal("reminder was not executed in 1s")
+	}
+
+	// Stop watching
+	cancel()
+	select {
+	case err := <-doneCh:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("WatchReminders did not return in 1s")
+	}
+}
+
+func TestReminderData(t *testing.T) {
+	store := newFakeStore()
+	clock := clocktesting.NewFakeClock(time.Now())
+	rm := newTestReminders(store, clock)
+	defer rm.processor.Close()
+	rm.opts.MaxDataSize = 16
+
+	t.Run("data is returned when acquiring reminders", func(t *testing.T) {
+		r := newReminder("withdata", clock.Now())
+		r.Data = json.RawMessage(`{"foo":"bar"}`)
+		require.NoError(t, rm.AddReminder(context.Background(), r))
+
+		next, err := acquireReminders(rm)
+		require.NoError(t, err)
+		require.Len(t, next, 1)
+		assert.JSONEq(t, `{"foo":"bar"}`, string(next[0].Data))
+	})
+
+	t.Run("data larger than the maximum size is rejected", func(t *testing.T) {
+		r := newReminder("toolarge", clock.Now())
+		r.Data = json.RawMessage(`{"hello":"world!"}`)
+		err := rm.AddReminder(context.Background(), r)
+		require.ErrorIs(t, err, reminders.ErrDataTooLarge)
+		assert.NotContains(t, store.reminders, r.Key())
+	})
+}
+
+func TestExpiredReminders(t *testing.T) {
+	store := newFakeStore()
+	clock := clocktesting.NewFakeClock(time.Now())
+	rm := newTestReminders(store, clock)
+	defer rm.processor.Close()
+
+	start := clock.Now()
+
+	t.Run("expired reminders are deleted when polling", func(t *testing.T) {
+		r := newReminder("expired", start.Add(time.Second))
+		r.TTL = start.Add(-time.Second)
+		require.NoError(t, rm.AddReminder(context.Background(), r))
+
+		next, err := acquireReminders(rm)
+		require.NoError(t, err)
+		assert.Empty(t, next)
+		assert.NotContains(t, store.reminders, r.Key())
+	})
+
+	t.Run("reminder that expires after being acquired is not executed", func(t *testing.T) {
+		r := newReminder("expiring", start.Add(time.Second))
+		r.TTL = start.Add(2 * time.Second)
+		require.NoError(t, rm.AddReminder(context.Background(), r))
+
+		next, err := acquireReminders(rm)
+		require.NoError(t, err)
+		require.Len(t, next, 1)
+
+		clock.Step(3 * time.Second)
+		require.NoError(t, rm.executeReminder(context.Background(), next[0]))
+		assert.NotContains(t, store.reminders, r.Key())
+	})
+
+	t.Run("repeating reminder stops at the TTL", func(t *testing.T) {
+		now := clock.Now()
+		r := newReminder("repeating", now)
+		r.Period = 10 * time.Second
+		r.TTL = now.Add(15 * time.Second)
+		require.NoError(t, rm.AddReminder(context.Background(), r))
+
+		// First iteration reschedules the reminder
+		next, err := acquireReminders(rm)
+		require.NoError(t, err)
+		require.Len(t, next, 1)
+		require.NoError(t, rm.executeReminder(context.Background(), next[0]))
+		require.Contains(t, store.reminders, r.Key())
+
+		// Second iteration deletes it because the third one would be past the TTL
+		clock.Step(10 * time.Second)
+		next, err = acquireReminders(rm)
+		require.NoError(t, err)
+		require.Len(t, next, 1)
+		require.NoError(t, rm.executeReminder(context.Background(), next[0]))
+		assert.NotContains(t, store.reminders, r.Key())
+	})
+}
+
+func TestExecuteReminder(t *testing.T) {
+	store := newFakeStore()
+	clock := clocktesting.NewFakeClock(time.Now())
+	rm := newTestReminders(store, clock)
+	defer rm.processor.Close()
+
+	acquire := func(t *testing.T, name string) *reminders.Reminder {
+		t.Helper()
+
+		require.NoError(t, rm.AddReminder(context.Background(), newReminder(name, clock.Now())))
+		next, err := acquireReminders(rm)
+		require.NoError(t, err)
+		require.Len(t, next, 1)
+		return next[0]
+	}
+
+	t.Run("reminder is removed when the callback succeeds", func(t *testing.T) {
+		r := acquire(t, "success")
+		executed := false
+		err := store.ExecuteReminder(context.Background(), r, rm.executeRequest(), func(ctx context.Context) error {
+			executed = true
+			return nil
+		})
+		require.NoError(t, err)
+		assert.True(t, executed)
+		assert.NotContains(t, store.reminders, r.Key())
+	})
+
+	t.Run("reminder is left in the store when the callback fails", func(t *testing.T) {
+		r := acquire(t, "failure")
+		err := store.ExecuteReminder(context.Background(), r, rm.executeRequest(), func(ctx context.Context) error {
+			return errors.New("simulated")
+		})
+		require.Error(t, err)
+		assert.ErrorContains(t, err, "simulated")
+
+		// The reminder is still leased, so it's not acquired again until the lease expires
+		_, ok := store.get(r.Key())
+		require.True(t, ok)
+		next, err := acquireReminders(rm)
+		require.NoError(t, err)
+		assert.Empty(t, next)
+	})
+
+	t.Run("callback is not invoked if the lease was lost", func(t *testing.T) {
+		r := acquire(t, "lost")
+		store.reminders[r.Key()].LeaseOwner = "someone-else"
+
+		err := store.ExecuteReminder(context.Background(), r, rm.executeRequest(), func(ctx context.Context) error {
+			t.Error("callback should not be invoked")
+			return nil
+		})
+		require.ErrorIs(t, err, reminders.ErrLeaseLost)
+	})
+
+	t.Run("context is canceled when the lease is lost during execution", func(t *testing.T) {
+		r := acquire(t, "canceled")
+
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- store.ExecuteReminder(context.Background(), r, rm.executeRequest(), func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			})
+		}()
+
+		// Steal the lease, then trigger the renewal
+		assert.Eventually(t, clock.HasWaiters, time.Second, 10*time.Millisecond)
+		store.lock.Lock()
+		store.reminders[r.Key()].LeaseOwner = "someone-else"
+		store.lock.Unlock()
+		clock.Step(rm.opts.leaseRenewInterval())
+
+		select {
+		case err := <-errCh:
+			require.ErrorIs(t, err, reminders.ErrLeaseLostDuringExecution)
+		case <-time.After(time.Second):
+			t.Fatal("execution did not complete in 1s")
+		}
+	})
+
+	t.Run("executions are canceled when stopping", func(t *testing.T) {
+		stopping := newTestReminders(store, clock)
+		startedCh := make(chan struct{})
+		stopping.executeFn = func(ctx context.Context, r *reminders.Reminder) error {
+			close(startedCh)
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		require.NoError(t, stopping.AddReminder(context.Background(), newReminder("stopping", clock.Now())))
+		next, err := acquireReminders(stopping)
+		require.NoError(t, err)
+		require.Len(t, next, 1)
+		require.NoError(t, stopping.processor.Enqueue(next[0]))
+
+		select {
+		case <-startedCh:
+		case <-time.After(time.Second):
+			t.Fatal("execution did not start in 1s")
+		}
+
+		// Renew the lease while the execution is blocked, so the token differs from the one the reminder was acquired with
+		assert.Eventually(t, clock.HasWaiters, time.Second, 10*time.Millisecond)
+		clock.Step(stopping.opts.leaseRenewInterval())
+		assert.Eventually(t, func() bool {
+			saved, _ := store.get(next[0].Key())
+			return saved.LeaseTime != next[0].LeaseTime
+		}, time.Second, 10*time.Millisecond)
+
+		require.NoError(t, stopping.Close(context.Background()))
+
+		// The canceled execution does not count as a failed attempt, and its lease is released so the reminder can be acquired again right away
+		saved, ok := store.get(next[0].Key())
+		require.True(t, ok)
+		assert.Zero(t, saved.Attempts)
+		assert.Empty(t, saved.LeaseOwner)
+		assert.Zero(t, saved.LeaseTime)
+	})
+
+	t.Run("leases of canceled executions are not released if they were lost", func(t *testing.T) {
+		store := newFakeStore()
+		stopping := newTestReminders(store, clock)
+		startedCh := make(chan struct{})
+		stopping.executeFn = func(ctx context.Context, r *reminders.Reminder) error {
+			close(startedCh)
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		require.NoError(t, stopping.AddReminder(context.Background(), newReminder("stolen", clock.Now())))
+		next, err := acquireReminders(stopping)
+		require.NoError(t, err)
+		require.Len(t, next, 1)
+		require.NoError(t, stopping.processor.Enqueue(next[0]))
+
+		select {
+		case <-startedCh:
+		case <-time.After(time.Second):
+			t.Fatal("execution did not start in 1s")
+		}
+
+		// Another instance acquires the reminder after the lease expired, but before the execution is canceled
+		store.lock.Lock()
+		store.reminders[next[0].Key()].LeaseOwner = "someone-else"
+		store.lock.Unlock()
+
+		require.NoError(t, stopping.Close(context.Background()))
+
+		saved, ok := store.get(next[0].Key())
+		require.True(t, ok)
+		assert.Equal(t, "someone-else", saved.LeaseOwner)
+	})
+}
+
+func TestReminderMetrics(t *testing.T) {
+	store := newFakeStore()
+	clock := clocktesting.NewFakeClock(time.Now())
+	reg := prometheus.NewRegistry()
+	metrics, err := reminders.NewMetrics(reg)
+	require.NoError(t, err)
+	opts := DefaultOptions()
+	opts.LocalEnqueue = false
+	rm := NewReminders(store, clock, opts, metrics, nil, nil)
+	defer rm.processor.Close()
+
+	// Returns the value of a counter or gauge, or the sum of a histogram
+	metricValue := func(t *testing.T, name string, labels map[string]string) float64 {
+		t.Helper()
+
+		families, err := reg.Gather()
+		require.NoError(t, err)
+		for _, f := range families {
+			if f.GetName() != name {
+				continue
+			}
+		metrics:
+			for _, m := range f.GetMetric() {
+				for _, l := range m.GetLabel() {
+					if labels[l.GetName()] != l.GetValue() {
+						continue metrics
+					}
+				}
+				switch {
+				case m.Counter != nil:
+					return m.Counter.GetValue()
+				case m.Gauge != nil:
+					return m.Gauge.GetValue()
+				case m.Histogram != nil:
+					return m.Histogram.GetSampleSum()
+				}
+			}
+		}
+		t.Fatalf("metric %s not found", name)
+		return 0
+	}
+
+	t.Run("execution lag is recorded", func(t *testing.T) {
+		// Reminder was due 2s ago
+		require.NoError(t, rm.AddReminder(context.Background(), newReminder("late", clock.Now().Add(-2*time.Second))))
+		next, err := acquireReminders(rm)
+		require.NoError(t, err)
+		require.Len(t, next, 1)
+
+		require.NoError(t, rm.executeReminder(context.Background(), next[0]))
+		assert.InDelta(t, 2.0, metricValue(t, "reminders_execution_lag_seconds", nil), 0.01)
+	})
+
+	t.Run("lost leases are counted", func(t *testing.T) {
+		require.NoError(t, rm.AddReminder(context.Background(), newReminder("lost", clock.Now())))
+		next, err := acquireReminders(rm)
+		require.NoError(t, err)
+		require.Len(t, next, 1)
+		store.reminders[next[0].Key()].LeaseOwner = "someone-else"
+
+		require.NoError(t, rm.executeReminder(context.Background(), next[0]))
+		assert.Equal(t, 1.0, metricValue(t, "reminders_lost_leases_total", map[string]string{"stage": reminders.LostLeaseBeforeExecution}))
+	})
+
+	t.Run("queue length is reported", func(t *testing.T) {
+		require.NoError(t, rm.AddReminder(context.Background(), newReminder("queued", clock.Now().Add(time.Second))))
+		next, err := acquireReminders(rm)
+		require.NoError(t, err)
+		rm.enqueueReminders(context.Background(), next)
+
+		assert.Equal(t, 1.0, metricValue(t, "reminders_queue_length", nil))
+	})
+}
+
+func TestTracing(t *testing.T) {
+	store := newFakeStore()
+	clock := clocktesting.NewFakeClock(time.Now())
+	exporter, tp := newTestTracerProvider()
+	opts := DefaultOptions()
+	opts.LocalEnqueue = false
+	rm := NewReminders(store, clock, opts, nil, tp, nil)
+	defer rm.processor.Close()
+
+	// Returns the span with the given name
+	findSpan := func(t *testing.T, name string) tracetest.SpanStub {
+		t.Helper()
+
+		for _, s := range exporter.GetSpans() {
+			if s.Name == name {
+				return s
+			}
+		}
+		t.Fatalf("span %s not found", name)
+		return tracetest.SpanStub{}
+	}
+
+	// Request comes with a trace context from the caller
+	handler := rm.tracingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := rm.AddReminder(r.Context(), newReminder("traced", clock.Now()))
+		require.NoError(t, err)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/reminder", nil)
+	req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	next, err := acquireReminders(rm)
+	require.NoError(t, err)
+	require.Len(t, next, 1)
+	rm.enqueueReminders(context.Background(), next)
+	require.NoError(t, rm.processor.Dequeue(next[0]))
+	require.NoError(t, rm.executeReminder(context.Background(), next[0]))
+
+	t.Run("request span continues the caller's trace", func(t *testing.T) {
+		span := findSpan(t, "POST /reminder")
+		assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", span.SpanContext.TraceID().String())
+		assert.Equal(t, "b7ad6b7169203331", span.Parent.SpanID().String())
+		assert.Contains(t, span.Attributes, attribute.Int("http.status_code", http.StatusNoContent))
+	})
+
+	t.Run("add span is a child of the request span", func(t *testing.T) {
+		span := findSpan(t, "reminders.add")
+		assert.Equal(t, findSpan(t, "POST /reminder").SpanContext.SpanID(), span.Parent.SpanID())
+		assert.Contains(t, span.Attributes, attribute.String("reminder.name", "traced"))
+	})
+
+	t.Run("enqueue and execute spans are linked to the add span", func(t *testing.T) {
+		add := findSpan(t, "reminders.add")
+		for _, name := range []string{"reminders.enqueue", "reminders.execute"} {
+			span := findSpan(t, name)
+			require.Len(t, span.Links, 1, name)
+			assert.Equal(t, add.SpanContext.SpanID(), span.Links[0].SpanContext.SpanID(), name)
+		}
+
+		// Executions are in their own trace
+		assert.NotEqual(t, add.SpanContext.TraceID(), findSpan(t, "reminders.execute").SpanContext.TraceID())
+	})
+
+	t.Run("errors are recorded on spans", func(t *testing.T) {
+		err := rm.AddReminder(context.Background(), &reminders.Reminder{ActorType: "myactor", ActorID: "myid", Name: "invalid", Repeats: -1})
+		require.Error(t, err)
+
+		spans := exporter.GetSpans()
+		assert.Equal(t, codes.Error, spans[len(spans)-1].Status.Code)
+	})
+}
+
+func TestDelivery(t *testing.T) {
+	// Stand-in for the actor app, which fails while failing is true
+	var (
+		lock    sync.Mutex
+		failing bool
+		paths   []string
+	)
+	app := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		paths = append(paths, r.URL.Path)
+		if failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer app.Close()
+	setFailing := func(v bool) {
+		lock.Lock()
+		failing = v
+		lock.Unlock()
+	}
+
+	store := newFakeStore()
+	clock := clocktesting.NewFakeClock(time.Now())
+	opts := DefaultOptions()
+	opts.LocalEnqueue = false
+	opts.AppAddress = app.URL
+	rm := NewReminders(store, clock, opts, nil, nil, nil)
+	defer rm.processor.Close()
+
+	t.Run("reminder is completed after it's delivered", func(t *testing.T) {
+		require.NoError(t, rm.AddReminder(context.Background(), newReminder("delivered", clock.Now())))
+		next, err := acquireReminders(rm)
+		require.NoError(t, err)
+		require.Len(t, next, 1)
+
+		require.NoError(t, rm.executeReminder(context.Background(), next[0]))
+		lock.Lock()
+		assert.Equal(t, []string{"/actors/myactor/myid/method/remind/delivered"}, paths)
+		lock.Unlock()
+		_, ok := store.get(next[0].Key())
+		assert.False(t, ok)
+	})
+
+	t.Run("failed deliveries are retried from the local queue", func(t *testing.T) {
+		setFailing(true)
+		require.NoError(t, rm.AddReminder(context.Background(), newReminder("failed", clock.Now())))
+		next, err := acquireReminders(rm)
+		require.NoError(t, err)
+		require.Len(t, next, 1)
+
+		// The reminder is due, so the processor executes it right away
+		require.NoError(t, rm.processor.Enqueue(next[0]))
+		assert.Eventually(t, func() bool {
+			saved, _ := store.get(next[0].Key())
+			return saved.Attempts == 1 && rm.processor.Len() == 1
+		}, time.Second, 10*time.Millisecond)
+
+		// The retry is scheduled with backoff, and we keep the lease because it's due within fetchAhead, so the reminder is back in the queue
+		saved, ok := store.get(next[0].Key())
+		require.True(t, ok)
+		assert.WithinRange(t, saved.RetryTime, clock.Now().Add(800*time.Millisecond), clock.Now().Add(1200*time.Millisecond))
+		assert.Equal(t, rm.ownerID, saved.LeaseOwner)
+
+		// Once the retry is due, the reminder is delivered again from the queue without waiting for the lease to expire
+		setFailing(false)
+		assert.Eventually(t, clock.HasWaiters, time.Second, 10*time.Millisecond)
+		clock.Step(2 * time.Second)
+		assert.Eventually(t, func() bool {
+			_, ok := store.get(next[0].Key())
+			return !ok
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("retries due later release the lease", func(t *testing.T) {
+		rm.opts.RetryInitialInterval = time.Minute
+		rm.opts.RetryJitter = 0
+		defer func() {
+			rm.opts.RetryInitialInterval = opts.RetryInitialInterval
+			rm.opts.RetryJitter = opts.RetryJitter
+		}()
+		setFailing(true)
+		defer setFailing(false)
+
+		require.NoError(t, rm.AddReminder(context.Background(), newReminder("later", clock.Now())))
+		next, err := acquireReminders(rm)
+		require.NoError(t, err)
+		require.Len(t, next, 1)
+		require.ErrorIs(t, rm.executeReminder(context.Background(), next[0]), reminders.ErrDeliveryFailed)
+
+		saved, ok := store.get(next[0].Key())
+		require.True(t, ok)
+		assert.Equal(t, 1, saved.Attempts)
+		assert.Equal(t, clock.Now().Add(time.Minute), saved.RetryTime)
+		assert.Empty(t, saved.LeaseOwner)
+		assert.Zero(t, rm.processor.Len())
+
+		// The reminder is not acquired again until the retry is due
+		next, err = acquireReminders(rm)
+		require.NoError(t, err)
+		assert.Empty(t, next)
+		clock.Step(time.Minute)
+		next, err = acquireReminders(rm)
+		require.NoError(t, err)
+		require.Len(t, next, 1)
+		assert.Equal(t, 1, next[0].Attempts)
+		require.NoError(t, rm.store.ReleaseLeases(context.Background(), next))
+		_, err = rm.store.DeleteReminder(context.Background(), next[0])
+		require.NoError(t, err)
+	})
+
+	t.Run("reminder is moved to the dead-letter table after the last attempt", func(t *testing.T) {
+		rm.opts.RetryMaxAttempts = 2
+		defer func() {
+			rm.opts.RetryMaxAttempts = opts.RetryMaxAttempts
+		}()
+		setFailing(true)
+		defer setFailing(false)
+
+		r := newReminder("exhausted", clock.Now())
+		r.Period = time.Hour
+		r.Data = json.RawMessage(`"hello"`)
+		require.NoError(t, rm.AddReminder(context.Background(), r))
+		next, err := acquireReminders(rm)
+		require.NoError(t, err)
+		require.Len(t, next, 1)
+		require.ErrorAs(t, rm.executeReminder(context.Background(), next[0]), new(*reminders.RetryError))
+
+		// The second attempt is the last one
+		saved, ok := store.get(r.Key())
+		require.True(t, ok)
+		require.Len(t, saved.FailedAttempts, 1)
+		err = rm.executeReminder(context.Background(), &saved)
+		require.ErrorIs(t, err, reminders.ErrRetriesExhausted)
+		require.ErrorIs(t, err, reminders.ErrDeliveryFailed)
+
+		// The reminder is removed, and the dead letter has the original schedule and the history of the attempts
+		_, ok = store.get(r.Key())
+		assert.False(t, ok)
+		deadLetters, err := store.ListDeadLetters(context.Background())
+		require.NoError(t, err)
+		require.Len(t, deadLetters, 1)
+		dl := deadLetters[0]
+		assert.Equal(t, r.Key(), dl.Reminder.Key())
+		assert.Equal(t, r.ExecutionTime, dl.Reminder.ExecutionTime)
+		assert.Equal(t, time.Hour, dl.Reminder.Period)
+		assert.Equal(t, r.Data, dl.Reminder.Data)
+		assert.Zero(t, dl.Reminder.Attempts)
+		assert.Empty(t, dl.Reminder.LeaseOwner)
+		assert.Equal(t, 2, dl.Attempts)
+		assert.Contains(t, dl.LastError, "503")
+		require.Len(t, dl.FailedAttempts, 2)
+		assert.Equal(t, 1, dl.FailedAttempts[0].Attempt)
+		assert.Equal(t, 2, dl.FailedAttempts[1].Attempt)
+
+		// Once requeued, the reminder is acquired and delivered again
+		setFailing(false)
+		require.NoError(t, rm.RequeueDeadLetter(context.Background(), dl.ID))
+		require.ErrorIs(t, rm.RequeueDeadLetter(context.Background(), dl.ID), reminders.ErrDeadLetterNotFound)
+		next, err = acquireReminders(rm)
+		require.NoError(t, err)
+		require.Len(t, next, 1)
+		assert.Zero(t, next[0].Attempts)
+		require.NoError(t, rm.executeReminder(context.Background(), next[0]))
+		saved, ok = store.get(r.Key())
+		require.True(t, ok)
+		assert.Equal(t, r.ExecutionTime.Add(time.Hour), saved.ExecutionTime)
+	})
+}
+
+func TestLogging(t *testing.T) {
+	store := newFakeStore()
+	clock := clocktesting.NewFakeClock(time.Now())
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	opts := DefaultOptions()
+	opts.LocalEnqueue = false
+	rm := NewReminders(store, clock, opts, nil, nil, logger)
+	defer rm.processor.Close()
+
+	// Returns the logged lines with the given message
+	logLines := func(t *testing.T, msg string) []map[string]any {
+		t.Helper()
+
+		res := []map[string]any{}
+		dec := json.NewDecoder(bytes.NewReader(buf.Bytes()))
+		for dec.More() {
+			line := map[string]any{}
+			require.NoError(t, dec.Decode(&line))
+			if line["msg"] == msg {
+				res = append(res, line)
+			}
+		}
+		return res
+	}
+
+	t.Run("executions are logged with the reminder fields and the lag", func(t *testing.T) {
+		require.NoError(t, rm.AddReminder(context.Background(), newReminder("logged", clock.Now().Add(-time.Second))))
+		next, err := acquireReminders(rm)
+		require.NoError(t, err)
+		require.Len(t, next, 1)
+		require.NoError(t, rm.executeReminder(context.Background(), next[0]))
+
+		lines := logLines(t, "Executing reminder")
+		require.Len(t, lines, 1)
+		assert.Equal(t, "DEBUG", lines[0]["level"])
+		assert.Equal(t, rm.ownerID, lines[0]["instance"])
+		assert.Equal(t, float64(time.Second), lines[0]["lag"])
+		assert.Equal(t, map[string]any{
+			"actorType":     "myactor",
+			"actorID":       "myid",
+			"name":          "logged",
+			"executionTime": next[0].ExecutionTime.Format(time.RFC3339Nano),
+			"leaseOwner":    rm.ownerID,
+			"leaseTime":     float64(next[0].LeaseTime),
+		}, lines[0]["reminder"])
+	})
+
+	t.Run("lost leases are logged as warnings", func(t *testing.T) {
+		require.NoError(t, rm.AddReminder(context.Background(), newReminder("lost", clock.Now())))
+		next, err := acquireReminders(rm)
+		require.NoError(t, err)
+		require.Len(t, next, 1)
+		store.reminders[next[0].Key()].LeaseOwner = "someone-else"
+		require.NoError(t, rm.executeReminder(context.Background(), next[0]))
+
+		lines := logLines(t, "Reminder cannot be executed because we lost the lease or the reminder was deleted")
+		require.Len(t, lines, 1)
+		assert.Equal(t, "WARN", lines[0]["level"])
+		assert.Equal(t, "lost", lines[0]["reminder"].(map[string]any)["name"])
+	})
+}
+
+// Returns a tracer provider that records spans in memory, so tests can inspect them.
+func newTestTracerProvider() (*tracetest.InMemoryExporter, *sdktrace.TracerProvider) {
+	exporter := tracetest.NewInMemoryExporter()
+	return exporter, sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+}
+
+func TestHostedActors(t *testing.T) {
+	store := newFakeStore()
+	clock := clocktesting.NewFakeClock(time.Now())
+	rm := newTestReminders(store, clock)
+	defer rm.processor.Close()
+
+	newActorReminder := func(actorType, actorID string) *reminders.Reminder {
+		return &reminders.Reminder{
+			ActorType:     actorType,
+			ActorID:       actorID,
+			Name:          "reminder",
+			ExecutionTime: clock.Now().Add(time.Second),
+		}
+	}
+	for _, r := range []*reminders.Reminder{
+		newActorReminder("type1", "id1"),
+		newActorReminder("type1", "id2"),
+		newActorReminder("type2", "id1"),
+		newActorReminder("type3", "id1"),
+	} {
+		require.NoError(t, rm.AddReminder(context.Background(), r))
+	}
+
+	// Acquires reminders and returns their keys, then releases the leases
+	acquireKeys := func(t *testing.T) []string {
+		t.Helper()
+
+		next, err := acquireReminders(rm)
+		require.NoError(t, err)
+		require.NoError(t, store.ReleaseLeases(context.Background(), next))
+		keys := make([]string, len(next))
+		for i, r := range next {
+			keys[i] = r.Key()
+		}
+		sort.Strings(keys)
+		return keys
+	}
+
+	rm.opts.BatchSize = 10
+
+	t.Run("reminders for all actors are acquired by default", func(t *testing.T) {
+		assert.Len(t, acquireKeys(t), 4)
+	})
+
+	t.Run("only reminders for hosted actor types are acquired", func(t *testing.T) {
+		rm.SetHostedActors([]reminders.ActorFilter{{ActorType: "type1"}, {ActorType: "type3"}})
+		assert.Equal(t, []string{"type1/id1/reminder", "type1/id2/reminder", "type3/id1/reminder"}, acquireKeys(t))
+	})
+
+	t.Run("only reminders for active actor IDs are acquired", func(t *testing.T) {
+		rm.SetHostedActors([]reminders.ActorFilter{{ActorType: "type1", ActorIDs: []string{"id2"}}, {ActorType: "type2"}})
+		assert.Equal(t, []string{"type1/id2/reminder", "type2/id1/reminder"}, acquireKeys(t))
+	})
+
+	t.Run("no reminders are acquired if no actors are hosted", func(t *testing.T) {
+		rm.SetHostedActors([]reminders.ActorFilter{})
+		assert.Empty(t, acquireKeys(t))
+	})
+
+	t.Run("reminders for actors that are not hosted are not enqueued locally", func(t *testing.T) {
+		rm.opts.LocalEnqueue = true
+		defer func() {
+			rm.opts.LocalEnqueue = false
+		}()
+		rm.SetHostedActors([]reminders.ActorFilter{{ActorType: "type1"}})
+
+		hosted := newActorReminder("type1", "local")
+		require.NoError(t, rm.AddReminder(context.Background(), hosted))
+		notHosted := newActorReminder("type2", "local")
+		require.NoError(t, rm.AddReminder(context.Background(), notHosted))
+
+		r, _ := store.get(hosted.Key())
+		assert.Equal(t, rm.ownerID, r.LeaseOwner)
+		r, _ = store.get(notHosted.Key())
+		assert.Empty(t, r.LeaseOwner)
+	})
+
+	t.Run("queued reminders for actors that are no longer hosted are released", func(t *testing.T) {
+		rm.SetHostedActors(nil)
+		next, err := acquireReminders(rm)
+		require.NoError(t, err)
+		require.NotEmpty(t, next)
+		rm.enqueueReminders(context.Background(), next)
+		queued := rm.processor.Len()
+
+		rm.SetHostedActors([]reminders.ActorFilter{{ActorType: "type1"}})
+
+		r, _ := store.get("type1/id1/reminder")
+		assert.Equal(t, rm.ownerID, r.LeaseOwner)
+		for _, key := range []string{"type2/id1/reminder", "type2/local/reminder", "type3/id1/reminder"} {
+			r, _ = store.get(key)
+			assert.Zero(t, r.LeaseTime, key)
+		}
+		assert.Equal(t, queued-3, rm.processor.Len())
+	})
+}
+
+// Returns a Reminders object for testing, with local enqueueing disabled so reminders are acquired only by polling.
+func newTestReminders(store reminders.ReminderStore, clock kclock.Clock) *Reminders {
+	opts := DefaultOptions()
+	opts.LocalEnqueue = false
+	return NewReminders(store, clock, opts, nil, nil, nil)
+}
+
+// Acquires the next reminders, like when polling.
+func acquireReminders(rm *Reminders) ([]*reminders.Reminder, error) {
+	return rm.store.AcquireReminders(context.Background(), rm.watchRequest().AcquireRequest(rm.clock.Now()))
+}
+
+func newReminder(name string, executionTime time.Time) *reminders.Reminder {
+	return &reminders.Reminder{
+		ActorType:     "myactor",
+		ActorID:       "myid",
+		Name:          name,
+		ExecutionTime: executionTime,
+	}
+}
+
+// fakeStore is an in-memory implementation of reminders.ReminderStore used for testing.
+type fakeStore struct {
+	lock         sync.Mutex
+	reminders    map[string]*reminders.Reminder
+	deadLetters  []*reminders.DeadLetter
+	deadLetterID int64
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{
+		reminders: make(map[string]*reminders.Reminder),
+	}
+}
+
+func (s *fakeStore) SaveReminder(ctx context.Context, r *reminders.Reminder) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	saved := *r
+	s.reminders[r.Key()] = &saved
+	return nil
+}
+
+func (s *fakeStore) DeleteReminder(ctx context.Context, r *reminders.Reminder) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	_, ok := s.reminders[r.Key()]
+	delete(s.reminders, r.Key())
+	return ok, nil
+}
+
+func (s *fakeStore) WatchReminders(ctx context.Context, req reminders.WatchRequest) (<-chan *reminders.Reminder, error) {
+	return reminders.WatchByPolling(ctx, s, req), nil
+}
+
+func (s *fakeStore) AcquireReminders(ctx context.Context, req reminders.AcquireRequest) ([]*reminders.Reminder, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := req.Now.UnixMilli()
+	res := make([]*reminders.Reminder, 0, len(s.reminders))
+	for key, r := range s.reminders {
+		if r.LeaseTime >= now-req.LeaseDuration.Milliseconds() {
+			continue
+		}
+		if r.Expired(req.Now) {
+			delete(s.reminders, key)
+			continue
+		}
+		if r.ScheduledTime().Before(req.Now.Add(req.FetchAhead)) && req.MatchesActor(r.ActorType, r.ActorID) {
+			res = append(res, r)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ScheduledTime().Before(res[j].ScheduledTime())
+	})
+	if len(res) > req.BatchSize {
+		res = res[:req.BatchSize]
+	}
+
+	// Acquire the leases and return copies
+	for i, r := range res {
+		r.LeaseOwner = req.Owner
+		r.LeaseTime = now
+		acquired := *r
+		res[i] = &acquired
+	}
+	return res, nil
+}
+
+func (s *fakeStore) RenewLease(ctx context.Context, r *reminders.Reminder, now time.Time) (int64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	existing, ok := s.reminders[r.Key()]
+	if !ok || !ownsLease(existing, r) {
+		return 0, reminders.ErrLeaseLost
+	}
+
+	existing.LeaseTime = now.UnixMilli()
+	return existing.LeaseTime, nil
+}
+
+func (s *fakeStore) ReleaseLeases(ctx context.Context, rs []*reminders.Reminder) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, r := range rs {
+		existing, ok := s.reminders[r.Key()]
+		if ok && ownsLease(existing, r) {
+			existing.LeaseOwner = ""
+			existing.LeaseTime = 0
+		}
+	}
+	return nil
+}
+
+func (s *fakeStore) CompleteReminder(ctx context.Context, r *reminders.Reminder, next *reminders.Reminder) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	existing, ok := s.reminders[r.Key()]
+	if !ok || !ownsLease(existing, r) {
+		return reminders.ErrLeaseLost
+	}
+
+	if next == nil {
+		delete(s.reminders, r.Key())
+	} else {
+		existing.ExecutionTime = next.ExecutionTime
+		existing.Repeats = next.Repeats
+		existing.Attempts = 0
+		existing.RetryTime = time.Time{}
+		existing.FailedAttempts = nil
+		existing.LeaseOwner = ""
+		existing.LeaseTime = 0
+	}
+	return nil
+}
+
+func (s *fakeStore) RetryReminder(ctx context.Context, r *reminders.Reminder, retry *reminders.Reminder) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	existing, ok := s.reminders[r.Key()]
+	if !ok || !ownsLease(existing, r) {
+		return reminders.ErrLeaseLost
+	}
+
+	existing.Attempts = retry.Attempts
+	existing.RetryTime = retry.RetryTime
+	existing.FailedAttempts = retry.FailedAttempts
+	existing.LeaseOwner = retry.LeaseOwner
+	existing.LeaseTime = retry.LeaseTime
+	return nil
+}
+
+func (s *fakeStore) ExecuteReminder(ctx context.Context, r *reminders.Reminder, req reminders.ExecuteRequest, executeFn reminders.ExecuteFn) error {
+	return reminders.ExecuteWithLeaseRenewal(ctx, s, r, req, executeFn)
+}
+
+func (s *fakeStore) ListLeases(ctx context.Context) ([]*reminders.Reminder, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	res := []*reminders.Reminder{}
+	for _, r := range s.reminders {
+		if r.LeaseOwner != "" {
+			leased := *r
+			res = append(res, &leased)
+		}
+	}
+	return res, nil
+}
+
+func (s *fakeStore) DeadLetterReminder(ctx context.Context, r *reminders.Reminder, dl *reminders.DeadLetter) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	existing, ok := s.reminders[r.Key()]
+	if !ok || !ownsLease(existing, r) {
+		return reminders.ErrLeaseLost
+	}
+
+	delete(s.reminders, r.Key())
+	s.deadLetterID++
+	dl.ID = s.deadLetterID
+	saved := *dl
+	s.deadLetters = append(s.deadLetters, &saved)
+	return nil
+}
+
+func (s *fakeStore) ListDeadLetters(ctx context.Context) ([]*reminders.DeadLetter, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	res := make([]*reminders.DeadLetter, len(s.deadLetters))
+	for i, dl := range s.deadLetters {
+		listed := *dl
+		res[i] = &listed
+	}
+	return res, nil
+}
+
+func (s *fakeStore) RequeueDeadLetter(ctx context.Context, id int64) (*reminders.Reminder, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for i, dl := range s.deadLetters {
+		if dl.ID != id {
+			continue
+		}
+		if _, ok := s.reminders[dl.Reminder.Key()]; ok {
+			return nil, reminders.ErrReminderExists
+		}
+		s.deadLetters = append(s.deadLetters[:i], s.deadLetters[i+1:]...)
+		saved := *dl.Reminder
+		s.reminders[saved.Key()] = &saved
+		requeued := saved
+		return &requeued, nil
+	}
+	return nil, reminders.ErrDeadLetterNotFound
+}
+
+func (s *fakeStore) PurgeDeadLetters(ctx context.Context, ids []int64) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	kept := []*reminders.DeadLetter{}
+	for _, dl := range s.deadLetters {
+		if ids != nil && !slices.Contains(ids, dl.ID) {
+			kept = append(kept, dl)
+		}
+	}
+	n := len(s.deadLetters) - len(kept)
+	s.deadLetters = kept
+	return n, nil
+}
+
+func ownsLease(existing *reminders.Reminder, r *reminders.Reminder) bool {
+	return existing.LeaseOwner == r.LeaseOwner && existing.LeaseTime == r.LeaseTime
+}
+
+func (s *fakeStore) get(key string) (reminders.Reminder, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	r, ok := s.reminders[key]
+	if !ok {
+		return reminders.Reminder{}, false
+	}
+	return *r, true
+}
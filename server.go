@@ -2,14 +2,21 @@ package main
 
 import (
 	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
 	"os"
 	"reminders-demo/pkg/reminders"
+	"strconv"
 	"time"
 
 	chi "github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Used in the demo app to have a way to pass input to the server
@@ -21,15 +28,22 @@ func (rm *Reminders) startServer() {
 
 	// Create the router
 	router := chi.NewRouter()
-	router.Use(middleware.Logger)
+	router.Use(rm.loggingMiddleware)
+	router.Use(rm.tracingMiddleware)
 
 	// POST /reminder - Create or update a reminder
 	router.Post("/reminder", func(w http.ResponseWriter, r *http.Request) {
 		req := &struct {
-			ActorID       string `json:"actorID,omitempty"`
-			ActorType     string `json:"actorType,omitempty"`
-			Name          string `json:"name,omitempty"`
-			ExecutionTime string `json:"executionTime,omitempty"`
+			ActorID        string          `json:"actorID,omitempty"`
+			ActorType      string          `json:"actorType,omitempty"`
+			Name           string          `json:"name,omitempty"`
+			ExecutionTime  string          `json:"executionTime,omitempty"`
+			DueTime        string          `json:"dueTime,omitempty"`
+			Period         string          `json:"period,omitempty"`
+			Cron           string          `json:"cron,omitempty"`
+			TimeZone       string          `json:"timeZone,omitempty"`
+			ExpirationTime string          `json:"expirationTime,omitempty"`
+			Data           json.RawMessage `json:"data,omitempty"`
 		}{}
 		err := json.NewDecoder(r.Body).Decode(req)
 		if err != nil {
@@ -53,37 +67,76 @@ func (rm *Reminders) startServer() {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
-		if req.ExecutionTime == "" {
+		// dueTime is accepted as an alias for executionTime, for compatibility with Dapr v1 reminders
+		if req.DueTime != "" {
+			if req.ExecutionTime != "" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("executionTime and dueTime cannot be both set"))
+				return
+			}
+			req.ExecutionTime = req.DueTime
+		}
+		if req.ExecutionTime == "" && req.Cron == "" {
 			w.Write([]byte("executionTime is empty"))
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
-		// If executionTime is in the format "+duration", interpret it as time from now
-		if len(req.ExecutionTime) > 1 && req.ExecutionTime[0] == '+' {
-			dur, err := time.ParseDuration(req.ExecutionTime[1:])
+
+		// Create the Reminder object
+		// If executionTime is empty, the first execution is scheduled according to the cron schedule
+		now := time.Now()
+		var executionTime time.Time
+		if req.ExecutionTime != "" {
+			executionTime, err = reminders.ParseTime(req.ExecutionTime, now)
 			if err != nil {
-				w.Write([]byte("Failed to parse executionTime as relative time: " + err.Error()))
 				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("Failed to parse executionTime: " + err.Error()))
 				return
 			}
-			req.ExecutionTime = time.Now().Add(dur).Format(time.RFC3339)
 		}
-
-		// Create the Reminder object
-		executionTime, err := time.Parse(time.RFC3339, req.ExecutionTime)
-		if err != nil {
-			w.Write([]byte("Failed to parse executionTime: " + err.Error()))
-			w.WriteHeader(http.StatusBadRequest)
-			return
+		var ttl time.Time
+		if req.ExpirationTime != "" {
+			ttl, err = reminders.ParseTime(req.ExpirationTime, now)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("Failed to parse expirationTime: " + err.Error()))
+				return
+			}
+		}
+		var (
+			period  time.Duration
+			repeats int
+		)
+		if req.Period != "" {
+			period, repeats, err = reminders.ParsePeriod(req.Period)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("Failed to parse period: " + err.Error()))
+				return
+			}
 		}
 		reminder := &reminders.Reminder{
 			ActorType:     req.ActorType,
 			ActorID:       req.ActorID,
 			Name:          req.Name,
 			ExecutionTime: executionTime,
+			Period:        period,
+			Repeats:       repeats,
+			Cron:          req.Cron,
+			TimeZone:      req.TimeZone,
+			TTL:           ttl,
+			Data:          req.Data,
 		}
 		err = rm.AddReminder(r.Context(), reminder)
-		if err != nil {
+		if errors.Is(err, reminders.ErrInvalidReminder) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Failed to add reminder: " + err.Error()))
+			return
+		} else if errors.Is(err, reminders.ErrDataTooLarge) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			w.Write([]byte("Failed to add reminder: " + err.Error()))
+			return
+		} else if err != nil {
 			w.Write([]byte("Failed to add reminder: " + err.Error()))
 			w.WriteHeader(http.StatusInternalServerError)
 			return
@@ -113,10 +166,238 @@ func (rm *Reminders) startServer() {
 		w.WriteHeader(http.StatusNoContent)
 	})
 
+	// GET /leases - Lists the reminders that have been leased, and which instance owns them
+	router.Get("/leases", func(w http.ResponseWriter, r *http.Request) {
+		leased, err := rm.store.ListLeases(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to list leases: " + err.Error()))
+			return
+		}
+
+		type leaseInfo struct {
+			ActorID       string    `json:"actorID"`
+			ActorType     string    `json:"actorType"`
+			Name          string    `json:"name"`
+			ExecutionTime time.Time `json:"executionTime"`
+			LeaseOwner    string    `json:"leaseOwner"`
+			LeaseTime     time.Time `json:"leaseTime"`
+			Active        bool      `json:"active"`
+			Self          bool      `json:"self"`
+		}
+		now := rm.clock.Now()
+		res := struct {
+			Owner  string      `json:"owner"`
+			Leases []leaseInfo `json:"leases"`
+		}{
+			Owner:  rm.ownerID,
+			Leases: make([]leaseInfo, len(leased)),
+		}
+		for i, l := range leased {
+			leaseTime := time.UnixMilli(l.LeaseTime)
+			res.Leases[i] = leaseInfo{
+				ActorID:       l.ActorID,
+				ActorType:     l.ActorType,
+				Name:          l.Name,
+				ExecutionTime: l.ExecutionTime,
+				LeaseOwner:    l.LeaseOwner,
+				LeaseTime:     leaseTime,
+				Active:        now.Sub(leaseTime) < rm.opts.LeaseDuration,
+				Self:          l.LeaseOwner == rm.ownerID,
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	})
+
+	// PUT /actors - Sets the actors hosted by this instance, whose reminders are acquired
+	// The body is a list of objects with "actorType" and, optionally, "actorIDs"; "null" means all actors
+	router.Put("/actors", func(w http.ResponseWriter, r *http.Request) {
+		var actors []reminders.ActorFilter
+		err := json.NewDecoder(r.Body).Decode(&actors)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Error parsing request body: " + err.Error()))
+			return
+		}
+		for _, a := range actors {
+			if a.ActorType == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("actorType is empty"))
+				return
+			}
+		}
+
+		rm.SetHostedActors(actors)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// GET /dead-letters - Lists the reminders that were moved to the dead-letter table after exhausting their attempts
+	router.Get("/dead-letters", func(w http.ResponseWriter, r *http.Request) {
+		deadLetters, err := rm.store.ListDeadLetters(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to list dead-lettered reminders: " + err.Error()))
+			return
+		}
+
+		type deadLetterInfo struct {
+			ID             int64                     `json:"id"`
+			ActorID        string                    `json:"actorID"`
+			ActorType      string                    `json:"actorType"`
+			Name           string                    `json:"name"`
+			ExecutionTime  time.Time                 `json:"executionTime"`
+			Period         string                    `json:"period,omitempty"`
+			Cron           string                    `json:"cron,omitempty"`
+			TimeZone       string                    `json:"timeZone,omitempty"`
+			Repeats        int                       `json:"repeats,omitempty"`
+			ExpirationTime *time.Time                `json:"expirationTime,omitempty"`
+			Data           json.RawMessage           `json:"data,omitempty"`
+			Attempts       int                       `json:"attempts"`
+			LastError      string                    `json:"lastError"`
+			FailedAttempts []reminders.FailedAttempt `json:"failedAttempts"`
+			DeadLetterTime time.Time                 `json:"deadLetterTime"`
+		}
+		res := make([]deadLetterInfo, len(deadLetters))
+		for i, dl := range deadLetters {
+			res[i] = deadLetterInfo{
+				ID:             dl.ID,
+				ActorID:        dl.Reminder.ActorID,
+				ActorType:      dl.Reminder.ActorType,
+				Name:           dl.Reminder.Name,
+				ExecutionTime:  dl.Reminder.ExecutionTime,
+				Cron:           dl.Reminder.Cron,
+				TimeZone:       dl.Reminder.TimeZone,
+				Repeats:        dl.Reminder.Repeats,
+				Data:           dl.Reminder.Data,
+				Attempts:       dl.Attempts,
+				LastError:      dl.LastError,
+				FailedAttempts: dl.FailedAttempts,
+				DeadLetterTime: dl.Time,
+			}
+			if dl.Reminder.Period > 0 {
+				res[i].Period = dl.Reminder.Period.String()
+			}
+			if !dl.Reminder.TTL.IsZero() {
+				res[i].ExpirationTime = &dl.Reminder.TTL
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	})
+
+	// POST /dead-letters/{id}/requeue - Moves a dead-lettered reminder back to the reminders table, with its original schedule
+	router.Post("/dead-letters/{id}/requeue", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("id is not valid"))
+			return
+		}
+
+		err = rm.RequeueDeadLetter(r.Context(), id)
+		if errors.Is(err, reminders.ErrDeadLetterNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("Failed to requeue reminder: " + err.Error()))
+			return
+		} else if errors.Is(err, reminders.ErrReminderExists) {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte("Failed to requeue reminder: " + err.Error()))
+			return
+		} else if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to requeue reminder: " + err.Error()))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// DELETE /dead-letters/{id} - Purges a dead-lettered reminder
+	router.Delete("/dead-letters/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("id is not valid"))
+			return
+		}
+
+		n, err := rm.store.PurgeDeadLetters(r.Context(), []int64{id})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to purge dead-lettered reminder: " + err.Error()))
+			return
+		}
+		if n == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("Failed to purge dead-lettered reminder: " + reminders.ErrDeadLetterNotFound.Error()))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// DELETE /dead-letters - Purges all dead-lettered reminders
+	router.Delete("/dead-letters", func(w http.ResponseWriter, r *http.Request) {
+		n, err := rm.store.PurgeDeadLetters(r.Context(), nil)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to purge dead-lettered reminders: " + err.Error()))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Purged int `json:"purged"`
+		}{Purged: n})
+	})
+
+	// GET /metrics - Exposes metrics in the Prometheus format
+	router.Handle("/metrics", promhttp.Handler())
+
 	// Start the server
-	log.Printf("Server listening on http://127.0.0.1:%s", port)
+	rm.logger.Info("Server listening", slog.String("address", "http://127.0.0.1:"+port))
 	err := http.ListenAndServe("127.0.0.1:"+port, router)
 	if err != http.ErrServerClosed {
-		log.Fatal(err)
+		fatal("Failed to start server", err)
 	}
 }
+
+// Middleware that starts a span for each request, continuing the trace from the W3C Trace Context headers, if any.
+func (rm *Reminders) tracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := propagation.TraceContext{}.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := rm.tracer().Start(ctx, r.Method+" "+r.URL.Path,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.method", r.Method),
+				attribute.String("http.target", r.URL.Path),
+			),
+		)
+		defer span.End()
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+		span.SetAttributes(attribute.Int("http.status_code", ww.Status()))
+		if ww.Status() >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(ww.Status()))
+		}
+	})
+}
+
+// Middleware that logs each request.
+func (rm *Reminders) loggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+		rm.logger.Info("Request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", ww.Status()),
+			slog.Duration("duration", time.Since(start)),
+		)
+	})
+}